@@ -0,0 +1,529 @@
+// Savgen generates sav encoders for struct types without reflection.
+//
+// Usage:
+//
+//	//go:generate savgen -type Survey
+//
+// For every type it writes a function returning the []sav.Dict of the struct,
+// derived from the sav struct tags like sav.DictFromStruct does, and a function
+// writing a value as a case with the typed Set calls of sav.SpssWriter:
+//
+//	func SurveySavDict() []sav.Dict
+//	func WriteSurveySav(out *sav.SpssWriter, v *Survey) error
+//
+// Variable names are checked while generating, so invalid or duplicate names
+// fail the build instead of being renamed at run time. Savgen supports fields of
+// basic types, time.Time, sql.Null* types and pointers to those; string fields
+// need a width option.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/librun/sav"
+)
+
+var (
+	typeNames = flag.String("type", "", "comma-separated list of struct type names; must be set")
+	output    = flag.String("output", "", "output file name; default <type>_sav.go")
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("savgen: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: savgen -type T [directory]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+
+	types := strings.Split(*typeNames, ",")
+	src, err := generate(dir, types)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	name := *output
+	if name == "" {
+		name = filepath.Join(dir, strings.ToLower(types[0])+"_sav.go")
+	}
+	if err := ioutil.WriteFile(name, src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// kinds of values a field can hold
+const (
+	kindInt = iota
+	kindUint
+	kindFloat
+	kindBool
+	kindString
+	kindTime
+)
+
+var basicKinds = map[string]int{
+	"int": kindInt, "int8": kindInt, "int16": kindInt, "int32": kindInt, "int64": kindInt, "rune": kindInt,
+	"uint": kindUint, "uint8": kindUint, "uint16": kindUint, "uint32": kindUint, "uint64": kindUint, "byte": kindUint,
+	"uintptr": kindUint, "float32": kindFloat, "float64": kindFloat, "bool": kindBool, "string": kindString,
+}
+
+// nullTypes maps the database/sql null types to their value field and kind
+var nullTypes = map[string]struct {
+	field string
+	kind  int
+}{
+	"NullBool":    {"Bool", kindBool},
+	"NullByte":    {"Byte", kindUint},
+	"NullFloat64": {"Float64", kindFloat},
+	"NullInt16":   {"Int16", kindInt},
+	"NullInt32":   {"Int32", kindInt},
+	"NullInt64":   {"Int64", kindInt},
+	"NullString":  {"String", kindString},
+	"NullTime":    {"Time", kindTime},
+}
+
+// field is a struct field to encode
+type field struct {
+	goName    string
+	kind      int
+	pointer   bool
+	nullField string // value field of a sql.Null* type
+	typeName  string // named type declared in the package
+	labels    bool   // the named type provides value labels
+	stringer  bool   // the named type has a String method
+	codes     []int  // code range of the labels option, labels from String
+	dict      sav.Dict
+}
+
+// pkg holds the declarations of the package
+type pkg struct {
+	name    string
+	types   map[string]*ast.TypeSpec
+	methods map[string]map[string]bool // methods by type, true for value receivers
+}
+
+func parsePackage(dir string) (*pkg, error) {
+	fset := token.NewFileSet()
+	notTest := func(fi os.FileInfo) bool { return !strings.HasSuffix(fi.Name(), "_test.go") }
+	pkgs, err := parser.ParseDir(fset, dir, notTest, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected one package in %s, found %d", dir, len(pkgs))
+	}
+
+	p := &pkg{types: make(map[string]*ast.TypeSpec), methods: make(map[string]map[string]bool)}
+	for name, astPkg := range pkgs {
+		p.name = name
+		for _, file := range astPkg.Files {
+			for _, decl := range file.Decls {
+				switch decl := decl.(type) {
+				case *ast.GenDecl:
+					for _, spec := range decl.Specs {
+						if ts, ok := spec.(*ast.TypeSpec); ok {
+							p.types[ts.Name.Name] = ts
+						}
+					}
+				case *ast.FuncDecl:
+					if decl.Recv == nil || len(decl.Recv.List) == 0 {
+						continue
+					}
+					recv := decl.Recv.List[0].Type
+					star, pointer := recv.(*ast.StarExpr)
+					if pointer {
+						recv = star.X
+					}
+					if id, ok := recv.(*ast.Ident); ok {
+						if p.methods[id.Name] == nil {
+							p.methods[id.Name] = make(map[string]bool)
+						}
+						// T(0) only has the methods with a value receiver
+						p.methods[id.Name][decl.Name.Name] = !pointer
+					}
+				}
+			}
+		}
+	}
+
+	return p, nil
+}
+
+func generate(dir string, types []string) ([]byte, error) {
+	p, err := parsePackage(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by savgen; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&buf, "package %s\n\n", p.name)
+	fmt.Fprintf(&buf, "import \"github.com/librun/sav\"\n")
+
+	for _, name := range types {
+		fields, err := p.structFields(name)
+		if err != nil {
+			return nil, err
+		}
+		writeDictFunc(&buf, name, fields)
+		writeCaseFunc(&buf, name, fields)
+	}
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w", err)
+	}
+
+	return src, nil
+}
+
+func (p *pkg) structFields(typeName string) ([]field, error) {
+	ts, found := p.types[typeName]
+	if !found {
+		return nil, fmt.Errorf("type %s not found", typeName)
+	}
+	st, ok := ts.Type.(*ast.StructType)
+	if !ok {
+		return nil, fmt.Errorf("type %s is not a struct", typeName)
+	}
+
+	var fields []field
+	names := make(map[string]string)
+	for _, f := range st.Fields.List {
+		var tag string
+		if f.Tag != nil {
+			unquoted, err := strconv.Unquote(f.Tag.Value)
+			if err != nil {
+				return nil, err
+			}
+			tag = reflect.StructTag(unquoted).Get("sav")
+		}
+		if tag == "-" {
+			continue
+		}
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported by savgen", typeName)
+		}
+
+		for _, ident := range f.Names {
+			if !ident.IsExported() {
+				continue
+			}
+
+			fd, err := p.field(ident.Name, f.Type, tag)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", typeName, ident.Name, err)
+			}
+			if other, found := names[strings.ToLower(fd.dict.Name)]; found {
+				return nil, fmt.Errorf("%s.%s: variable name %s is already used by %s", typeName, ident.Name, fd.dict.Name, other)
+			}
+			names[strings.ToLower(fd.dict.Name)] = ident.Name
+
+			fields = append(fields, fd)
+		}
+	}
+
+	return fields, nil
+}
+
+// field resolves the type and tag options of a struct field
+func (p *pkg) field(goName string, expr ast.Expr, tag string) (field, error) {
+	f := field{goName: goName}
+	if star, ok := expr.(*ast.StarExpr); ok {
+		f.pointer = true
+		expr = star.X
+	}
+
+	switch t := expr.(type) {
+	case *ast.Ident:
+		if kind, found := basicKinds[t.Name]; found {
+			f.kind = kind
+			break
+		}
+		ts, found := p.types[t.Name]
+		if !found {
+			return f, fmt.Errorf("unsupported type %s", t.Name)
+		}
+		underlying, ok := ts.Type.(*ast.Ident)
+		if !ok {
+			return f, fmt.Errorf("unsupported type %s", t.Name)
+		}
+		kind, found := basicKinds[underlying.Name]
+		if !found {
+			return f, fmt.Errorf("unsupported type %s", t.Name)
+		}
+		f.kind = kind
+		f.typeName = t.Name
+		f.labels = (kind == kindInt || kind == kindUint) && p.methods[t.Name]["SavLabels"]
+		f.stringer = (kind == kindInt || kind == kindUint) && p.methods[t.Name]["String"]
+	case *ast.SelectorExpr:
+		pkgIdent, _ := t.X.(*ast.Ident)
+		switch {
+		case pkgIdent != nil && pkgIdent.Name == "time" && t.Sel.Name == "Time":
+			f.kind = kindTime
+		case pkgIdent != nil && pkgIdent.Name == "sql" && !f.pointer:
+			null, found := nullTypes[t.Sel.Name]
+			if !found {
+				return f, fmt.Errorf("unsupported type sql.%s", t.Sel.Name)
+			}
+			f.kind = null.kind
+			f.nullField = null.field
+		default:
+			return f, fmt.Errorf("unsupported type %s.%s", pkgIdent, t.Sel.Name)
+		}
+	default:
+		return f, fmt.Errorf("unsupported field type, use sav.StructEncoder for nested structs and series")
+	}
+
+	return f, f.parseTag(tag)
+}
+
+// parseTag fills the dictionary entry from the kind of the field and its sav tag
+func (f *field) parseTag(tag string) error {
+	parts := strings.Split(tag, ",")
+	f.dict.Name = parts[0]
+	if f.dict.Name == "" {
+		f.dict.Name = f.goName
+	}
+	if err := sav.CheckVarName(f.dict.Name); err != nil {
+		return err
+	}
+
+	switch f.kind {
+	case kindInt, kindUint:
+		zero := 0
+		f.dict.Decimals = &zero
+	case kindFloat:
+		scale := "scale"
+		f.dict.Measure = &scale
+	case kindBool:
+		f.dict.Type = sav.DictTypeBool
+	case kindString:
+		f.dict.Type = sav.DictTypeString
+	case kindTime:
+		f.dict.Type = sav.DictTypeDatetime
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("sav tag option %q is not supported by savgen", opt)
+		}
+		key, value := strings.TrimSpace(kv[0]), kv[1]
+
+		switch key {
+		case "type":
+			if f.kind == kindTime && (value == "date" || value == "datetime") {
+				if value == "date" {
+					f.dict.Type = sav.DictTypeDate
+				}
+				continue
+			}
+			if value != typeOption(f.dict.Type) {
+				return fmt.Errorf("type %s is not supported by savgen for this field", value)
+			}
+		case "label":
+			f.dict.Label = value
+		case "measure":
+			if value != "scale" && value != "nominal" && value != "ordinal" {
+				return fmt.Errorf("unknown value for measure %s", value)
+			}
+			measure := value
+			f.dict.Measure = &measure
+		case "width", "decimals", "columns":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			switch key {
+			case "width":
+				f.dict.Width = &n
+			case "decimals":
+				f.dict.Decimals = &n
+			default:
+				f.dict.Columns = &n
+			}
+		case "align":
+			if value != "left" && value != "right" && value != "center" {
+				return fmt.Errorf("unknown value for align %s", value)
+			}
+			align := value
+			f.dict.Alignment = &align
+		case "default":
+			def := value
+			f.dict.Default = &def
+		case "labels":
+			from, to, err := sav.ParseCodeRange(value)
+			if err != nil {
+				return err
+			}
+			if !f.stringer {
+				return fmt.Errorf("labels option needs an integer type with a String method")
+			}
+			f.codes = []int{from, to}
+		default:
+			return fmt.Errorf("sav tag option %s is not supported by savgen", key)
+		}
+	}
+
+	if f.kind == kindString && f.dict.Width == nil {
+		return fmt.Errorf("string variable %s needs a width option", f.dict.Name)
+	}
+
+	return nil
+}
+
+func typeOption(t sav.DictType) string {
+	switch t {
+	case sav.DictTypeDate:
+		return "date"
+	case sav.DictTypeDatetime:
+		return "datetime"
+	case sav.DictTypeString:
+		return "string"
+	case sav.DictTypeBool:
+		return "bool"
+	}
+	return "numeric"
+}
+
+func dictTypeName(t sav.DictType) string {
+	switch t {
+	case sav.DictTypeDate:
+		return "sav.DictTypeDate"
+	case sav.DictTypeDatetime:
+		return "sav.DictTypeDatetime"
+	case sav.DictTypeString:
+		return "sav.DictTypeString"
+	case sav.DictTypeBool:
+		return "sav.DictTypeBool"
+	}
+	return "sav.DictTypeNumeric"
+}
+
+// funcName returns the name of a generated function, unexported for unexported types
+func funcName(typeName, format string) string {
+	name := fmt.Sprintf(format, strings.ToUpper(typeName[:1])+typeName[1:])
+	if !ast.IsExported(typeName) {
+		r := []rune(name)
+		r[0] = unicode.ToLower(r[0])
+		name = string(r)
+	}
+	return name
+}
+
+func writeDictFunc(buf *bytes.Buffer, typeName string, fields []field) {
+	var ints []int
+	var strs []string
+	var entries []string
+	for _, f := range fields {
+		d := f.dict
+		entry := fmt.Sprintf("{Name: %q, Type: %s", d.Name, dictTypeName(d.Type))
+		if d.Label != "" {
+			entry += fmt.Sprintf(", Label: %q", d.Label)
+		}
+		if d.Width != nil {
+			entry += fmt.Sprintf(", Width: &ints[%d]", len(ints))
+			ints = append(ints, *d.Width)
+		}
+		if d.Decimals != nil {
+			entry += fmt.Sprintf(", Decimals: &ints[%d]", len(ints))
+			ints = append(ints, *d.Decimals)
+		}
+		if d.Measure != nil {
+			entry += fmt.Sprintf(", Measure: &strs[%d]", len(strs))
+			strs = append(strs, *d.Measure)
+		}
+		if d.Default != nil {
+			entry += fmt.Sprintf(", Default: &strs[%d]", len(strs))
+			strs = append(strs, *d.Default)
+		}
+		if d.Columns != nil {
+			entry += fmt.Sprintf(", Columns: &ints[%d]", len(ints))
+			ints = append(ints, *d.Columns)
+		}
+		if d.Alignment != nil {
+			entry += fmt.Sprintf(", Alignment: &strs[%d]", len(strs))
+			strs = append(strs, *d.Alignment)
+		}
+		if f.codes != nil {
+			entry += fmt.Sprintf(", Labels: sav.StringerLabels(%s(0), %d, %d)", f.typeName, f.codes[0], f.codes[1])
+		} else if f.labels {
+			entry += fmt.Sprintf(", Labels: sav.TypeLabels(%s(0))", f.typeName)
+		}
+		entries = append(entries, entry+"},")
+	}
+
+	fmt.Fprintf(buf, "\n// %s returns the sav dictionary of %s\n", funcName(typeName, "%sSavDict"), typeName)
+	fmt.Fprintf(buf, "func %s() []sav.Dict {\n", funcName(typeName, "%sSavDict"))
+	if len(ints) > 0 {
+		fmt.Fprintf(buf, "ints := %#v\n", ints)
+	}
+	if len(strs) > 0 {
+		fmt.Fprintf(buf, "strs := %#v\n", strs)
+	}
+	fmt.Fprintf(buf, "return []sav.Dict{\n%s\n}\n}\n", strings.Join(entries, "\n"))
+}
+
+func writeCaseFunc(buf *bytes.Buffer, typeName string, fields []field) {
+	name := funcName(typeName, "Write%sSav")
+	fmt.Fprintf(buf, "\n// %s writes v as a case, the dictionary of out must come from %s\n", name, funcName(typeName, "%sSavDict"))
+	fmt.Fprintf(buf, "func %s(out *sav.SpssWriter, v *%s) error {\n", name, typeName)
+	fmt.Fprintf(buf, "out.ClearCase()\n")
+
+	for _, f := range fields {
+		value := "v." + f.goName
+		switch {
+		case f.pointer:
+			fmt.Fprintf(buf, "if %s == nil {\nout.SetMissing(%q)\n} else {\n", value, f.dict.Name)
+			value = "*" + value
+		case f.nullField != "":
+			fmt.Fprintf(buf, "if !%s.Valid {\nout.SetMissing(%q)\n} else {\n", value, f.dict.Name)
+			value += "." + f.nullField
+		}
+
+		switch f.kind {
+		case kindInt, kindUint, kindFloat:
+			fmt.Fprintf(buf, "out.SetNumber(%q, float64(%s))\n", f.dict.Name, value)
+		case kindBool:
+			fmt.Fprintf(buf, "if %s {\nout.SetNumber(%q, 1)\n} else {\nout.SetNumber(%q, 0)\n}\n", value, f.dict.Name, f.dict.Name)
+		case kindString:
+			if f.typeName != "" {
+				value = "string(" + value + ")"
+			}
+			fmt.Fprintf(buf, "out.SetString(%q, %s)\n", f.dict.Name, value)
+		case kindTime:
+			fmt.Fprintf(buf, "out.SetTime(%q, %s)\n", f.dict.Name, value)
+		}
+
+		if f.pointer || f.nullField != "" {
+			fmt.Fprintf(buf, "}\n")
+		}
+	}
+
+	fmt.Fprintf(buf, "\nreturn out.WriteCase()\n}\n")
+}
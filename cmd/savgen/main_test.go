@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	src, err := generate("testdata/survey", []string{"Survey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{
+		"package survey",
+		"func SurveySavDict() []sav.Dict {",
+		`{Name: "name", Type: sav.DictTypeString, Label: "Your name", Width: &ints[1]},`,
+		`{Name: "birth", Type: sav.DictTypeDate},`,
+		`Labels: sav.TypeLabels(Frequency(0))`,
+		`Labels: sav.StringerLabels(Level(0), 0, 2)`,
+		"func WriteSurveySav(out *sav.SpssWriter, v *Survey) error {",
+		`out.SetNumber("id", float64(v.ID))`,
+		`out.SetTime("birth", *v.Birth)`,
+		`out.SetNumber("count", float64(v.Count.Int64))`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("generated code does not contain %s:\n%s", want, src)
+		}
+	}
+
+	for _, unwanted := range []string{"Skip", "hidden", "Mood(0)"} {
+		if strings.Contains(string(src), unwanted) {
+			t.Errorf("generated code contains %s", unwanted)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	for _, name := range []string{"BadName", "Duplicate", "NoWidth", "Nested", "Missing", "NoStringer"} {
+		if _, err := generate("testdata/invalid", []string{name}); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestGenerateCompiles(t *testing.T) {
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	src, err := generate("testdata/survey", []string{"Survey"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a directory in the module, so the generated code imports this sav package
+	dir, err := ioutil.TempDir("testdata", "compile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	survey, err := ioutil.ReadFile("testdata/survey/survey.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "survey.go"), survey, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "survey_sav.go"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(goTool, "vet", "./"+filepath.ToSlash(dir))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("generated code does not compile: %v\n%s\n%s", err, out, src)
+	}
+}
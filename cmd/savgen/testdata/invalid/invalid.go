@@ -0,0 +1,22 @@
+package invalid
+
+type BadName struct {
+	ID int `sav:"1st"`
+}
+
+type Duplicate struct {
+	A int `sav:"code"`
+	B int `sav:"CODE"`
+}
+
+type NoWidth struct {
+	Name string `sav:"name"`
+}
+
+type Nested struct {
+	Inner struct{ A int }
+}
+
+type NoStringer struct {
+	Code int `sav:"code,labels=1-5"`
+}
@@ -0,0 +1,37 @@
+package survey
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Frequency int
+
+func (Frequency) SavLabels() map[int]string {
+	return map[int]string{1: "Never", 2: "Often"}
+}
+
+type Level int
+
+func (l Level) String() string {
+	return [...]string{"low", "middle", "high"}[l]
+}
+
+type Mood int
+
+func (*Mood) SavLabels() map[int]string {
+	return map[int]string{1: "Bad", 2: "Good"}
+}
+
+type Survey struct {
+	ID       int           `sav:"id,measure=scale"`
+	Name     string        `sav:"name,width=20,label=Your name"`
+	Finished bool          `sav:"finished"`
+	Birth    *time.Time    `sav:"birth,type=date"`
+	Freq     Frequency     `sav:"freq"`
+	Count    sql.NullInt64 `sav:"count"`
+	Level    Level         `sav:"level,labels=0-2"`
+	Mood     Mood          `sav:"mood"`
+	Skip     string        `sav:"-"`
+	hidden   int
+}
@@ -0,0 +1,232 @@
+package sav_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readSav is a minimal system file reader used to check what the writer produced
+type readSav struct {
+	ProdName string
+	CaseSize int32
+	NCases   int32
+	Bias     float64
+	Date     string
+	Time     string
+	Label    string
+	Vars     []readVar
+	Labels   []readLabels
+	Ext      map[int32][]byte
+	Cases    [][][8]byte
+}
+
+type readVar struct {
+	Type  int32
+	Name  string
+	Label string
+	Print int32
+}
+
+type readLabels struct {
+	Values [][8]byte
+	Descs  []string
+	Vars   []int32
+}
+
+func (f *readSav) num(c, index int) float64 {
+	return math.Float64frombits(binary.LittleEndian.Uint64(f.Cases[c][index][:]))
+}
+
+func (f *readSav) str(c, index, elements int) string {
+	var buf bytes.Buffer
+	for i := 0; i < elements; i++ {
+		buf.Write(f.Cases[c][index+i][:])
+	}
+	return string(bytes.TrimRight(buf.Bytes(), " "))
+}
+
+func (f *readSav) varIndex(name string) int {
+	for i, v := range f.Vars {
+		if v.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func readSavFile(t *testing.T, path string) *readSav {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parseSav(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func parseSav(r io.Reader) (*readSav, error) {
+	f := &readSav{Ext: make(map[int32][]byte)}
+	le := binary.LittleEndian
+	var header struct {
+		Magic    [4]byte
+		ProdName [60]byte
+		Layout   int32
+		CaseSize int32
+		Compress int32
+		Weight   int32
+		NCases   int32
+		Bias     float64
+		Date     [9]byte
+		Time     [8]byte
+		Label    [64]byte
+		Pad      [3]byte
+	}
+	if err := binary.Read(r, le, &header); err != nil {
+		return nil, err
+	}
+	if string(header.Magic[:]) != "$FL2" {
+		return nil, fmt.Errorf("bad magic %q", header.Magic)
+	}
+	f.ProdName = string(bytes.TrimRight(header.ProdName[:], " "))
+	f.CaseSize = header.CaseSize
+	f.NCases = header.NCases
+	f.Bias = header.Bias
+	f.Date = string(header.Date[:])
+	f.Time = string(header.Time[:])
+	f.Label = string(bytes.TrimRight(header.Label[:], " "))
+
+	readInt := func() int32 {
+		var i int32
+		binary.Read(r, le, &i)
+		return i
+	}
+
+	for {
+		var recType int32
+		if err := binary.Read(r, le, &recType); err != nil {
+			return nil, err
+		}
+		switch recType {
+		case 2:
+			var rec struct {
+				Type, HasLabel, NMissing, Print, Write int32
+				Name                                   [8]byte
+			}
+			if err := binary.Read(r, le, &rec); err != nil {
+				return nil, err
+			}
+			v := readVar{Type: rec.Type, Name: string(bytes.TrimRight(rec.Name[:], " ")), Print: rec.Print}
+			if rec.HasLabel == 1 {
+				l := readInt()
+				buf := make([]byte, (l+3)/4*4)
+				if _, err := io.ReadFull(r, buf); err != nil {
+					return nil, err
+				}
+				v.Label = string(buf[:l])
+			}
+			if rec.NMissing != 0 {
+				n := rec.NMissing
+				if n < 0 {
+					n = -n
+				}
+				io.CopyN(ioutil.Discard, r, int64(n)*8)
+			}
+			f.Vars = append(f.Vars, v)
+		case 3:
+			var set readLabels
+			n := readInt()
+			for i := int32(0); i < n; i++ {
+				var value [8]byte
+				var l byte
+				binary.Read(r, le, &value)
+				binary.Read(r, le, &l)
+				buf := make([]byte, (int(l)+8)/8*8-1)
+				if _, err := io.ReadFull(r, buf); err != nil {
+					return nil, err
+				}
+				set.Values = append(set.Values, value)
+				set.Descs = append(set.Descs, string(buf[:l]))
+			}
+			if readInt() != 4 {
+				return nil, fmt.Errorf("value label record not followed by record type 4")
+			}
+			n = readInt()
+			for i := int32(0); i < n; i++ {
+				set.Vars = append(set.Vars, readInt())
+			}
+			f.Labels = append(f.Labels, set)
+		case 6:
+			n := readInt()
+			io.CopyN(ioutil.Discard, r, int64(n)*80)
+		case 7:
+			subtype, size, count := readInt(), readInt(), readInt()
+			buf := make([]byte, size*count)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return nil, err
+			}
+			f.Ext[subtype] = buf
+		case 999:
+			readInt()
+			return f, f.readCases(r)
+		default:
+			return nil, fmt.Errorf("unknown record type %d", recType)
+		}
+	}
+}
+
+func (f *readSav) readCases(r io.Reader) error {
+	var current [][8]byte
+	var command [8]byte
+	for {
+		if _, err := io.ReadFull(r, command[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		for _, c := range command {
+			var el [8]byte
+			switch c {
+			case 0:
+				continue
+			case 252:
+				return nil
+			case 253:
+				if _, err := io.ReadFull(r, el[:]); err != nil {
+					return err
+				}
+			case 254:
+				copy(el[:], "        ")
+			case 255:
+				binary.LittleEndian.PutUint64(el[:], math.Float64bits(-math.MaxFloat64))
+			default:
+				binary.LittleEndian.PutUint64(el[:], math.Float64bits(float64(c)-f.Bias))
+			}
+			current = append(current, el)
+			if int32(len(current)) == f.CaseSize {
+				f.Cases = append(f.Cases, current)
+				current = nil
+			}
+		}
+	}
+}
+
+// tempSavPath returns a base path for NewNativeSav in a fresh directory and a function removing it
+func tempSavPath(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "sav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "test"), func() { os.RemoveAll(dir) }
+}
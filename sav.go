@@ -23,19 +23,22 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"io"
 	"log"
 	"math"
-	"math/rand"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
 	maxStringLength     = 1024 * 50
 	maxPrintStringWidth = 40
+	defaultProductName  = "xml2sav 2.0"
 	TimeOffset          = 12219379200
 	SPSS_NUMERIC        = 0
 
@@ -47,6 +50,10 @@ const (
 	SPSS_MLVL_NOM = 1
 	SPSS_MLVL_ORD = 2
 	SPSS_MLVL_RAT = 3
+
+	SPSS_ALIGN_LEFT   = 0
+	SPSS_ALIGN_RIGHT  = 1
+	SPSS_ALIGN_CENTER = 2
 )
 
 type Label struct {
@@ -54,6 +61,13 @@ type Label struct {
 	Desc  string
 }
 
+// LabelSet is a named list of value labels shared by several variables, the
+// labels are written once for all variables of the same type using the set
+type LabelSet struct {
+	Name   string
+	Labels []Label
+}
+
 type DictType int
 
 const (
@@ -61,26 +75,42 @@ const (
 	DictTypeDate
 	DictTypeDatetime
 	DictTypeString
+	DictTypeBool
+)
+
+// Default spellings accepted for DictTypeBool variables, compared case-insensitively
+var (
+	DefaultTrueValues  = []string{"true", "yes", "1"}
+	DefaultFalseValues = []string{"false", "no", "0"}
 )
 
 type Var struct {
-	ColumnIndex int32
-	Index       int32
-	Name        string
-	ShortName   string
-	Type        DictType
-	TypeSize    int32
-	Print       byte
-	Width       byte
-	Decimals    byte
-	Measure     int32
-	Label       string
-	Default     string
-	HasDefault  bool
-	Labels      []Label
-	Value       string
-	HasValue    bool
-	Segments    int // how many segments
+	ColumnIndex  int32
+	Index        int32
+	Name         string
+	ShortName    string
+	Type         DictType
+	TypeSize     int32
+	Print        byte
+	Width        byte
+	Decimals     byte
+	Measure      int32
+	Columns      int32 // display width in Data View, 0 for the default of the type
+	Alignment    int32 // SPSS_ALIGN_LEFT, SPSS_ALIGN_RIGHT or SPSS_ALIGN_CENTER, used when HasAlignment
+	HasAlignment bool
+	Label        string
+	Default      string
+	HasDefault   bool
+	Labels       []Label
+	LabelSet     *LabelSet // shared value labels, Labels override or add values
+	Value        string
+	HasValue     bool
+	Number       float64 // value set by SetNumber, used instead of Value when HasNumber
+	HasNumber    bool
+	Segments     int           // how many segments
+	NumberFormat *NumberFormat // overrides SpssWriter.NumberFormat
+	TrueValues   []string      // accepted true values for DictTypeBool
+	FalseValues  []string      // accepted false values for DictTypeBool
 }
 
 // SegmentWidth returns the width of the given segment
@@ -94,6 +124,31 @@ func (v *Var) SegmentWidth(index int) int32 {
 	return v.TypeSize - int32(v.Segments-1)*252
 }
 
+// parseBool converts a value of a DictTypeBool variable to 1 or 0
+func (v *Var) parseBool(val string) (float64, error) {
+	trueValues, falseValues := v.TrueValues, v.FalseValues
+	if trueValues == nil {
+		trueValues = DefaultTrueValues
+	}
+	if falseValues == nil {
+		falseValues = DefaultFalseValues
+	}
+
+	val = strings.TrimSpace(val)
+	for _, t := range trueValues {
+		if strings.EqualFold(val, t) {
+			return 1, nil
+		}
+	}
+	for _, f := range falseValues {
+		if strings.EqualFold(val, f) {
+			return 0, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown boolean value %q", val)
+}
+
 var endian = binary.LittleEndian
 
 type SpssWriter struct {
@@ -103,10 +158,23 @@ type SpssWriter struct {
 	Dict             []*Var          // Variables
 	DictMap          map[string]*Var // Long variable names index
 	ShortMap         map[string]*Var // Short variable names index
-	Count            int32           // Number of cases
+	Count            int64           // Number of cases
 	Index            int32
 	ColumnIndex      int32
 	IgnoreMissingVar bool
+	NamePolicy       NamePolicy       // Handling of invalid variable names, see Validate
+	NumberFormat     *NumberFormat    // Parsing of numeric values, nil means strconv.ParseFloat
+	Warn             func(Warning)    // Receives truncated and invalid values, nil logs them
+	Encoding         *Encoding        // Encoding of names, labels and strings, nil means UTF-8
+	Now              func() time.Time // Creation time in the header, nil means time.Now
+	ProductName      string           // Product in the header, "xml2sav 2.0" when empty
+	ProductInfo      string           // Text of the extra product info record, not written when empty
+	varSets          []varSet         // See AddVarSet
+	dateInfo         []DateComponent  // See SetDateInfo
+	// DisableCaseCount64 leaves out the extended number of cases record,
+	// writing more than math.MaxInt32 cases then fails
+	DisableCaseCount64 bool
+	caseCount64Offset  int64 // position of the count in the extended number of cases record
 }
 
 func NewSpssWriter(w io.WriteSeeker) *SpssWriter {
@@ -220,32 +288,37 @@ func getAlpabetByInt(s int) string {
 	}
 }
 
-func atof(s string) float64 {
-	v, err := strconv.ParseFloat(s, 32)
-	if err != nil {
-		log.Fatalln(err)
-	}
-	return v
-}
-
 func elementCount(width int32) int32 {
 	return ((width - 1) / 8) + 1
 }
 
-var cleanVarNameRegExp = regexp.MustCompile(`[^A-Za-z0-9#\$_\.]`)
+var cleanVarNameRegExp = regexp.MustCompile(`[^\p{L}\p{M}\p{Nd}#\$_\.]`)
+
+// CheckVarName returns an error when n is not kept as is by the variable name cleaning of AddVar
+func CheckVarName(n string) error {
+	if clean := cleanVarName(n); clean != n {
+		return fmt.Errorf("invalid variable name %q, it would be changed to %q", n, clean)
+	}
+	return nil
+}
 
 func cleanVarName(n string) string {
+	at := strings.HasPrefix(n, "@")
 	n = cleanVarNameRegExp.ReplaceAllLiteralString(n, "")
+	n = strings.TrimRight(n, "._")
 	if len(n) == 0 {
 		n = "illegal"
 	}
-	if (n[0] < 'a' || n[0] > 'z') && (n[0] < 'A' || n[0] > 'Z') {
+	if first, _ := utf8.DecodeRuneInString(n); at || !unicode.IsLetter(first) || isReservedWord(n) {
 		n = "@" + n
 	}
-	if len(n) > 64 {
-		n = n[:64]
-	}
-	return n
+	return strings.TrimRight(truncateUTF8(n, 64), "._")
+}
+
+// setOutput replaces the writer before anything is written
+func (out *SpssWriter) setOutput(w io.WriteSeeker) {
+	out.seeker = w
+	out.Writer.Reset(w)
 }
 
 func (out *SpssWriter) caseSize() int32 {
@@ -292,12 +365,19 @@ func (out *SpssWriter) writeString(v *Var, val string) error {
 
 func (out *SpssWriter) headerRecord(fileLabel string) error {
 	c := time.Now()
+	if out.Now != nil {
+		c = out.Now()
+	}
 
 	if _, err := out.Write(stob("$FL2", 4)); err != nil { // rec_tyoe
 		return err
 	}
 
-	if _, err := out.Write(stob("@(#) SPSS DATA FILE - xml2sav 2.0", 60)); err != nil { // prod_name
+	product := out.ProductName
+	if product == "" {
+		product = defaultProductName
+	}
+	if _, err := out.Write(stob(out.truncate(out.encodeText("", "@(#) SPSS DATA FILE - "+product), 60), 60)); err != nil { // prod_name
 		return err
 	}
 
@@ -333,7 +413,7 @@ func (out *SpssWriter) headerRecord(fileLabel string) error {
 		return err
 	}
 
-	if _, err := out.Write(stob(fileLabel, 64)); err != nil { // file_label
+	if _, err := out.Write(stob(out.truncate(out.encodeText("", fileLabel), 64), 64)); err != nil { // file_label
 		return err
 	}
 
@@ -353,11 +433,72 @@ func (out *SpssWriter) updateHeaderNCases() error {
 		return err
 	}
 
-	return binary.Write(out.seeker, endian, out.Count) // ncases in headerRecord
+	ncases := int32(-1) // unknown when it does not fit
+	if out.Count <= math.MaxInt32 {
+		ncases = int32(out.Count)
+	}
+	if err := binary.Write(out.seeker, endian, ncases); err != nil { // ncases in headerRecord
+		return err
+	}
+
+	if out.caseCount64Offset == 0 {
+		return nil
+	}
+	if _, err := out.Seek(out.caseCount64Offset, 0); err != nil {
+		return err
+	}
+
+	return binary.Write(out.seeker, endian, out.Count) // ncases in extendedCaseCountRecord
+}
+
+func (out *SpssWriter) extendedCaseCountRecord() error {
+	if out.DisableCaseCount64 {
+		return nil
+	}
+
+	if err := binary.Write(out, endian, int32(7)); err != nil { // rec_type
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(16)); err != nil { // subtype
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(8)); err != nil { // size
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(2)); err != nil { // count
+		return err
+	}
+
+	if err := binary.Write(out, endian, int64(1)); err != nil { // unknown
+		return err
+	}
+
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	offset, err := out.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	out.caseCount64Offset = offset
+
+	return binary.Write(out, endian, int64(-1)) // ncases64, updated by Finish
+}
+
+// checkCaseCount returns an error when another case can not be counted
+func (out *SpssWriter) checkCaseCount() error {
+	if out.DisableCaseCount64 && out.Count >= math.MaxInt32 {
+		return fmt.Errorf("more than %d cases need the extended number of cases record", int32(math.MaxInt32))
+	}
+	return nil
 }
 
 func (out *SpssWriter) variableRecords() error {
 	for _, v := range out.Dict {
+		label := out.encodeText(v.Name, v.Label)
 		for segment := 0; segment < v.Segments; segment++ {
 			width := v.SegmentWidth(segment)
 			if err := binary.Write(out, endian, int32(2)); err != nil { // rec_type
@@ -367,7 +508,7 @@ func (out *SpssWriter) variableRecords() error {
 			if err := binary.Write(out, endian, width); err != nil { // type (0 or strlen)
 				return err
 			}
-			if segment == 0 && len(v.Label) > 0 {
+			if segment == 0 && len(label) > 0 {
 				if err := binary.Write(out, endian, int32(1)); err != nil { // has_var_label
 					return err
 				}
@@ -400,16 +541,16 @@ func (out *SpssWriter) variableRecords() error {
 					return err
 				}
 
-				if len(v.Label) > 0 {
-					if err := binary.Write(out, endian, int32(len(v.Label))); err != nil { // label_len
+				if len(label) > 0 {
+					if err := binary.Write(out, endian, int32(len(label))); err != nil { // label_len
 						return err
 					}
 
-					if _, err := out.Write([]byte(v.Label)); err != nil { // label
+					if _, err := out.Write([]byte(label)); err != nil { // label
 						return err
 					}
 
-					pad := (4 - len(v.Label)) % 4
+					pad := (4 - len(label)) % 4
 					if pad < 0 {
 						pad += 4
 					}
@@ -464,35 +605,64 @@ func (out *SpssWriter) variableRecords() error {
 }
 
 func (out *SpssWriter) valueLabelRecords() error {
+	type shared struct {
+		set     *LabelSet
+		numeric bool
+		print   byte // date labels are parsed with the format of the variable
+	}
+	written := make(map[shared]bool)
 	for _, v := range out.Dict {
-		if len(v.Labels) > 0 && v.TypeSize <= 8 {
+		if v.TypeSize > 8 {
+			continue
+		}
+
+		labels, indexes, narrowest := v.valueLabels(), []int32{v.Index}, v
+		if v.LabelSet != nil && len(v.Labels) == 0 { // one record for all variables of the same type
+			key := shared{v.LabelSet, v.TypeSize == 0, v.Print}
+			if written[key] {
+				continue
+			}
+			written[key] = true
+
+			indexes = nil
+			for _, other := range out.Dict {
+				if other.TypeSize <= 8 && len(other.Labels) == 0 && (shared{other.LabelSet, other.TypeSize == 0, other.Print}) == key {
+					indexes = append(indexes, other.Index)
+					if other.TypeSize < narrowest.TypeSize {
+						narrowest = other // string values must fit every variable
+					}
+				}
+			}
+		}
+
+		if len(labels) == 0 {
+			continue
+		}
+		if checked := out.checkLabels(narrowest, labels); len(checked) > 0 {
 			if err := binary.Write(out, endian, int32(3)); err != nil { // rec_type
 				return err
 			}
 
-			if err := binary.Write(out, endian, int32(len(v.Labels))); err != nil { // label_count
+			if err := binary.Write(out, endian, int32(len(checked))); err != nil { // label_count
 				return err
 			}
 
-			for _, label := range v.Labels {
+			for _, label := range checked {
 				if v.TypeSize == 0 {
-					if err := binary.Write(out, endian, atof(label.Value)); err != nil { // value
+					if err := binary.Write(out, endian, label.number); err != nil { // value
 						return err
 					}
 				} else {
-					if err := binary.Write(out, endian, stob(label.Value, 8)); err != nil { // value
+					if err := binary.Write(out, endian, stob(label.value, 8)); err != nil { // value
 						return err
 					}
 				}
-				l := len(label.Desc)
-				if l > 120 {
-					l = 120
-				}
+				l := len(label.desc)
 				if err := binary.Write(out, endian, byte(l)); err != nil { // label_len
 					return err
 				}
 
-				if _, err := out.Write(stob(label.Desc, l)); err != nil { // label
+				if _, err := out.Write([]byte(label.desc)); err != nil { // label
 					return err
 				}
 
@@ -511,11 +681,11 @@ func (out *SpssWriter) valueLabelRecords() error {
 				return err
 			}
 
-			if err := binary.Write(out, endian, int32(1)); err != nil { // var_count
+			if err := binary.Write(out, endian, int32(len(indexes))); err != nil { // var_count
 				return err
 			}
 
-			if err := binary.Write(out, endian, int32(v.Index)); err != nil { // vars
+			if err := binary.Write(out, endian, indexes); err != nil { // vars
 				return err
 			}
 		}
@@ -569,7 +739,7 @@ func (out *SpssWriter) machineIntegerInfoRecord() error {
 		return err
 	}
 
-	if err := binary.Write(out, endian, int32(65001)); err != nil { // character_code
+	if err := binary.Write(out, endian, out.encoding().CodePage); err != nil { // character_code
 		return err
 	}
 
@@ -608,6 +778,35 @@ func (out *SpssWriter) machineFloatingPointInfoRecord() error {
 	return nil
 }
 
+func (out *SpssWriter) extraProductInfoRecord() error {
+	if out.ProductInfo == "" {
+		return nil
+	}
+
+	if err := binary.Write(out, endian, int32(7)); err != nil { // rec_type
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(10)); err != nil { // subtype
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(1)); err != nil { // size
+		return err
+	}
+
+	info := out.encodeText("", out.ProductInfo)
+	if err := binary.Write(out, endian, int32(len(info))); err != nil { // count
+		return err
+	}
+
+	if _, err := out.Write([]byte(info)); err != nil { // info
+		return err
+	}
+
+	return nil
+}
+
 func (out *SpssWriter) variableDisplayParameterRecord() error {
 	if err := binary.Write(out, endian, int32(7)); err != nil { // rec_type
 		return err
@@ -631,31 +830,29 @@ func (out *SpssWriter) variableDisplayParameterRecord() error {
 				return err
 			}
 
+			width, alignment := int32(8), int32(SPSS_ALIGN_RIGHT)
 			if v.TypeSize > 0 {
-				if s != 0 {
-					if err := binary.Write(out, endian, int32(8)); err != nil { // width
-						return err
+				alignment = SPSS_ALIGN_LEFT
+				if s == 0 {
+					width = v.TypeSize
+					if width > int32(maxPrintStringWidth) {
+						width = int32(maxPrintStringWidth)
 					}
-				} else if v.TypeSize <= int32(maxPrintStringWidth) {
-					if err := binary.Write(out, endian, v.TypeSize); err != nil { // width
-						return err
-					}
-				} else {
-					if err := binary.Write(out, endian, int32(maxPrintStringWidth)); err != nil { // width
-						return err
-					}
-				}
-				if err := binary.Write(out, endian, int32(0)); err != nil { // alignment (left)
-					return err
-				}
-			} else {
-				if err := binary.Write(out, endian, int32(8)); err != nil { // width
-					return err
 				}
+			}
+			if v.Columns > 0 && s == 0 {
+				width = v.Columns
+			}
+			if v.HasAlignment {
+				alignment = v.Alignment
+			}
 
-				if err := binary.Write(out, endian, int32(1)); err != nil { // alignment (right)
-					return err
-				}
+			if err := binary.Write(out, endian, width); err != nil { // width
+				return err
+			}
+
+			if err := binary.Write(out, endian, alignment); err != nil { // alignment
+				return err
 			}
 		}
 	}
@@ -687,7 +884,7 @@ func (out *SpssWriter) longVarNameRecords() error {
 			return err
 		}
 
-		if _, err := buf.Write([]byte(v.Name)); err != nil {
+		if _, err := buf.Write([]byte(out.encodeText(v.Name, v.Name))); err != nil {
 			return err
 		}
 
@@ -778,11 +975,12 @@ func (out *SpssWriter) encodingRecord() error {
 		return err
 	}
 
-	if err := binary.Write(out, endian, int32(5)); err != nil { // filler
+	name := out.encoding().Name
+	if err := binary.Write(out, endian, int32(len(name))); err != nil { // count
 		return err
 	}
 
-	if _, err := out.Write(stob("UTF-8", 5)); err != nil { // encoding
+	if _, err := out.Write([]byte(name)); err != nil { // encoding
 		return err
 	}
 
@@ -793,7 +991,7 @@ func (out *SpssWriter) longStringValueLabelsRecord() error {
 	// Check if we have any
 	any := false
 	for _, v := range out.Dict {
-		if len(v.Labels) > 0 && v.TypeSize > 8 {
+		if len(v.valueLabels()) > 0 && v.TypeSize > 8 {
 			any = true
 			break
 		}
@@ -805,7 +1003,10 @@ func (out *SpssWriter) longStringValueLabelsRecord() error {
 	// Create record
 	buf := new(bytes.Buffer)
 	for _, v := range out.Dict {
-		if len(v.Labels) > 0 && v.TypeSize > 8 {
+		if v.TypeSize <= 8 {
+			continue
+		}
+		if labels := out.checkLabels(v, v.valueLabels()); len(labels) > 0 {
 			if err := binary.Write(buf, endian, int32(len(v.ShortName))); err != nil { // var_name_len
 				return err
 			}
@@ -818,30 +1019,35 @@ func (out *SpssWriter) longStringValueLabelsRecord() error {
 				return err
 			}
 
-			if err := binary.Write(buf, endian, int32(len(v.Labels))); err != nil { // n_labels
+			if err := binary.Write(buf, endian, int32(len(labels))); err != nil { // n_labels
 				return err
 			}
 
-			for _, l := range v.Labels {
-				if err := binary.Write(buf, endian, int32(len(l.Value))); err != nil { // value_len
+			for _, l := range labels {
+				value, desc := l.value, l.desc
+				if err := binary.Write(buf, endian, int32(len(value))); err != nil { // value_len
 					return err
 				}
 
-				if _, err := buf.Write([]byte(l.Value)); err != nil { // value
+				if _, err := buf.Write([]byte(value)); err != nil { // value
 					return err
 				}
 
-				if err := binary.Write(buf, endian, int32(len(l.Desc))); err != nil { // label_len
+				if err := binary.Write(buf, endian, int32(len(desc))); err != nil { // label_len
 					return err
 				}
 
-				if _, err := buf.Write([]byte(l.Desc)); err != nil { //label
+				if _, err := buf.Write([]byte(desc)); err != nil { //label
 					return err
 				}
 			}
 		}
 	}
 
+	if buf.Len() == 0 { // all labels left out
+		return nil
+	}
+
 	if err := binary.Write(out, endian, int32(7)); err != nil { // rec_type
 		return err
 	}
@@ -877,8 +1083,55 @@ func (out *SpssWriter) terminationRecord() error {
 	return nil
 }
 
+// valueLabels returns the labels of the label set with the labels of the variable
+// replacing the ones with the same value
+func (v *Var) valueLabels() []Label {
+	if v.LabelSet == nil {
+		return v.Labels
+	}
+	if len(v.Labels) == 0 {
+		return v.LabelSet.Labels
+	}
+
+	labels := make([]Label, 0, len(v.LabelSet.Labels)+len(v.Labels))
+	own := make(map[string]bool, len(v.Labels))
+	for _, l := range v.Labels {
+		own[l.Value] = true
+	}
+	for _, l := range v.LabelSet.Labels {
+		if !own[l.Value] {
+			labels = append(labels, l)
+		}
+	}
+
+	return append(labels, v.Labels...)
+}
+
+// shortNameBase returns up to 5 upper case ASCII characters of a long name
+// for its short name, "V" for names without ASCII letters or digits
+func shortNameBase(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if b.Len() == 5 {
+			break
+		}
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@#$_.", r) {
+			b.WriteRune(r)
+		}
+	}
+
+	base := b.String()
+	if base == "" {
+		return "V"
+	}
+	if (base[0] < 'A' || base[0] > 'Z') && base[0] != '@' {
+		return trim("@"+base, 5)
+	}
+	return base
+}
+
 func (out *SpssWriter) makeShortName(v *Var, segment int) string {
-	baseName := strings.ToUpper(trim(v.Name, 5))
+	baseName := shortNameBase(v.Name)
 	short := baseName + "0"
 
 	for {
@@ -890,8 +1143,8 @@ func (out *SpssWriter) makeShortName(v *Var, segment int) string {
 		appendText := strings.ToUpper(ConvertIntToColumnName(segment))
 		segment++
 
-		if len(appendText) > 3 { // Come up with random name
-			short = "@" + strconv.Itoa(rand.Int()%10000000)
+		if len(appendText) > 3 { // Come up with a numbered name
+			short = "@" + strconv.Itoa(segment)
 		} else {
 			short = baseName + appendText
 		}
@@ -906,18 +1159,21 @@ func (out *SpssWriter) AddVar(v *Var) {
 		log.Fatalf("maximum length for a variable is %d, %s is %d", maxStringLength, v.Name, v.TypeSize)
 	}
 
-	// Clean variable name
 	origName := v.Name
-	name := cleanVarName(v.Name)
-	if name != v.Name {
-		log.Printf("Change variable name '%s' to '%s'\n", v.Name, name)
-		v.Name = name
-	}
-
 	if _, found := out.DictMap[origName]; found {
 		log.Fatalln("Adding duplicate variable named", origName)
 	}
 
+	out.layoutVar(v)
+	out.ColumnIndex++
+	v.ColumnIndex = out.ColumnIndex
+
+	out.Dict = append(out.Dict, v)
+	out.DictMap[origName] = v
+}
+
+// layoutVar sets the segments and dictionary index of v as the next variable
+func (out *SpssWriter) layoutVar(v *Var) {
 	v.Segments = 1
 	if v.TypeSize > 255 {
 		v.Segments = (int(v.TypeSize) + 251) / 252
@@ -927,115 +1183,95 @@ func (out *SpssWriter) AddVar(v *Var) {
 	for i := 0; i < v.Segments; i++ {
 		out.Index += elementCount(v.SegmentWidth(i))
 	}
-	out.ColumnIndex++
-	v.ColumnIndex = out.ColumnIndex
+}
 
-	out.Dict = append(out.Dict, v)
-	out.DictMap[origName] = v
+// relayout recalculates segments and indexes after string widths changed
+func (out *SpssWriter) relayout() {
+	out.Index = 1
+	for _, v := range out.Dict {
+		out.layoutVar(v)
+	}
 }
 
 func (out *SpssWriter) ClearCase() {
 	for _, v := range out.Dict {
 		v.Value = ""
 		v.HasValue = false
+		v.HasNumber = false
 	}
 }
 
-func (out *SpssWriter) SetVar(name, value string) {
+// lookupVar returns the variable for a Set call, nil when it should be ignored
+func (out *SpssWriter) lookupVar(name string) *Var {
 	v, found := out.DictMap[name]
 	if !found {
 		if out.IgnoreMissingVar {
-			return
+			return nil
 		}
 		log.Fatalln("Can not find the variable named in dictionary", name)
 	}
-	v.Value = value
-	v.HasValue = true
+	return v
+}
+
+func (out *SpssWriter) SetVar(name, value string) {
+	if v := out.lookupVar(name); v != nil {
+		v.Value = value
+		v.HasValue = true
+		v.HasNumber = false
+	}
+}
+
+// SetString sets the value of a string variable, it is the same as SetVar
+func (out *SpssWriter) SetString(name, value string) {
+	out.SetVar(name, value)
+}
+
+// SetNumber sets the value of a numeric variable without parsing text, NaN is set as missing
+func (out *SpssWriter) SetNumber(name string, value float64) {
+	if math.IsNaN(value) {
+		out.SetMissing(name)
+		return
+	}
+	if v := out.lookupVar(name); v != nil {
+		v.Number = value
+		v.Value = strconv.FormatFloat(value, 'g', -1, 64) // for string variables
+		v.HasNumber = true
+		v.HasValue = true
+	}
+}
+
+// SetTime sets the value of a date or datetime variable, the zero time is set as missing.
+// The wall clock of t is used, just like for text values.
+func (out *SpssWriter) SetTime(name string, t time.Time) {
+	if t.IsZero() {
+		out.SetMissing(name)
+		return
+	}
+	v := out.lookupVar(name)
+	if v == nil {
+		return
+	}
+	if v.Print == SPSS_FMT_DATE {
+		t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	} else {
+		t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	}
+	out.SetNumber(name, float64(t.Unix()+TimeOffset))
+}
+
+// SetMissing sets a variable to missing (blank for strings), the default value is not used
+func (out *SpssWriter) SetMissing(name string) {
+	out.SetVar(name, "")
 }
 
 func (out *SpssWriter) WriteCase() error {
+	if err := out.checkCaseCount(); err != nil {
+		return err
+	}
 	for _, v := range out.Dict {
-		if v.HasValue || v.HasDefault {
-			var val string
-			if v.HasValue {
-				val = v.Value
-			} else {
-				val = v.Default
-			}
-
-			if v.TypeSize > 0 { // string
-				if len(val) > int(v.TypeSize) {
-					val = val[:v.TypeSize]
-					log.Printf("Truncated string for %s: %s\n", v.Name, val)
-				}
-				if err := out.writeString(v, val); err != nil {
-					return err
-				}
-			} else if v.Print == SPSS_FMT_DATE {
-				if val == "" {
-					if err := binary.Write(out, endian, -math.MaxFloat64); err != nil { // Write missing
-						return err
-					}
-				} else {
-					t, err := time.Parse("2-Jan-2006", v.Value)
-					if err != nil {
-						log.Printf("Problem pasing value for %s: %s - set as missing\n", v.Name, err)
-						if err := out.bytecode.WriteMissing(); err != nil {
-							return err
-						}
-					} else {
-						if err := out.bytecode.WriteNumber(float64(t.Unix() + TimeOffset)); err != nil {
-							return err
-						}
-					}
-				}
-			} else if v.Print == SPSS_FMT_DATE_TIME {
-				if val == "" {
-					if err := out.bytecode.WriteMissing(); err != nil {
-						return err
-					}
-				} else {
-					t, err := time.Parse("2-Jan-2006 15:04:05", v.Value)
-					if err != nil {
-						log.Printf("Problem pasing value for %s: %s - set as missing\n", v.Name, err)
-						if err := out.bytecode.WriteMissing(); err != nil {
-							return err
-						}
-					} else {
-						if err := out.bytecode.WriteNumber(float64(t.Unix() + TimeOffset)); err != nil {
-							return err
-						}
-					}
-				}
-			} else { // number
-				if val == "" {
-					if err := out.bytecode.WriteMissing(); err != nil {
-						return err
-					}
-				} else {
-					f, err := strconv.ParseFloat(val, 64)
-					if err != nil {
-						log.Printf("Problem pasing value for %s: %s - set as missing\n", v.Name, err)
-						if err := out.bytecode.WriteMissing(); err != nil {
-							return err
-						}
-					} else {
-						if err := out.bytecode.WriteNumber(f); err != nil {
-							return err
-						}
-					}
-				}
-			}
-		} else { // Write missing value
-			if v.TypeSize > 0 {
-				if err := out.writeString(v, ""); err != nil {
-					return err
-				}
-			} else {
-				if err := out.bytecode.WriteMissing(); err != nil {
-					return err
-				}
-			}
+		str, number, ok := out.caseValue(v)
+		if err := out.writeValue(v, str, number, ok); err != nil {
+			return err
 		}
 	}
 	out.Count++
@@ -1043,7 +1279,104 @@ func (out *SpssWriter) WriteCase() error {
 	return nil
 }
 
+// isDate reports whether v is a date or datetime variable
+func (v *Var) isDate() bool {
+	return v.Print == SPSS_FMT_DATE || v.Print == SPSS_FMT_DATE_TIME
+}
+
+// parseTime parses a date or datetime value to SPSS seconds
+func (v *Var) parseTime(val string) (float64, error) {
+	layout := "2-Jan-2006"
+	if v.Print == SPSS_FMT_DATE_TIME {
+		layout = "2-Jan-2006 15:04:05"
+	}
+	t, err := time.Parse(layout, val)
+	if err != nil {
+		return 0, err
+	}
+
+	return float64(t.Unix() + TimeOffset), nil
+}
+
+// caseValue returns the value of v in the current case, str for string
+// variables and number for the others. ok is false for a missing number.
+func (out *SpssWriter) caseValue(v *Var) (str string, number float64, ok bool) {
+	if v.HasNumber && v.TypeSize == 0 {
+		return "", v.Number, true
+	}
+	if !v.HasValue && !v.HasDefault {
+		return "", 0, false
+	}
+
+	val := v.Default
+	if v.HasValue {
+		val = v.Value
+	}
+
+	if v.TypeSize > 0 { // string
+		encoded := out.encodeValue(v.Name, val, out.Count)
+		if len(encoded) > int(v.TypeSize) {
+			out.warn(Warning{Kind: WarningTruncated, Var: v.Name, Case: out.Count, Value: val, Length: len(encoded), Width: int(v.TypeSize)})
+			encoded = out.truncate(encoded, int(v.TypeSize))
+		}
+		val = encoded
+		return val, 0, true
+	}
+
+	if val == "" {
+		return "", 0, false
+	}
+
+	var err error
+	if v.isDate() {
+		number, err = v.parseTime(val)
+	} else if v.Type == DictTypeBool {
+		number, err = v.parseBool(val)
+	} else { // number
+		nf := v.NumberFormat
+		if nf == nil {
+			nf = out.NumberFormat
+		}
+		number, err = nf.Parse(val)
+	}
+	if err != nil {
+		out.warn(Warning{Kind: WarningInvalid, Var: v.Name, Case: out.Count, Value: val, Length: len(val), Err: err})
+		return "", 0, false
+	}
+
+	return "", number, true
+}
+
+// writeValue writes a value returned by caseValue
+func (out *SpssWriter) writeValue(v *Var, str string, number float64, ok bool) error {
+	if v.TypeSize > 0 {
+		return out.writeString(v, str)
+	}
+	if !ok {
+		return out.bytecode.WriteMissing()
+	}
+
+	return out.bytecode.WriteNumber(number)
+}
+
+// prepare checks the dictionary before it is written
+func (out *SpssWriter) prepare() error {
+	if err := out.applyNamePolicy(); err != nil {
+		return err
+	}
+
+	if err := out.checkDateInfo(); err != nil {
+		return err
+	}
+
+	return out.checkVarSets()
+}
+
 func (out *SpssWriter) Start(fileLabel string) error {
+	if err := out.prepare(); err != nil {
+		return err
+	}
+
 	if err := out.headerRecord(fileLabel); err != nil {
 		return err
 	}
@@ -1064,6 +1397,22 @@ func (out *SpssWriter) Start(fileLabel string) error {
 		return err
 	}
 
+	if err := out.varSetsRecord(); err != nil {
+		return err
+	}
+
+	if err := out.dateInfoRecord(); err != nil {
+		return err
+	}
+
+	if err := out.extraProductInfoRecord(); err != nil {
+		return err
+	}
+
+	if err := out.extendedCaseCountRecord(); err != nil {
+		return err
+	}
+
 	if err := out.variableDisplayParameterRecord(); err != nil {
 		return err
 	}
@@ -0,0 +1,76 @@
+package sav
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DateComponent is a level of the date structure made by Define Dates, for
+// example the year and the quarter of quarterly data
+type DateComponent struct {
+	Var         string // name of the numeric variable holding the level, e.g. YEAR_
+	Start       int32  // value of the first case
+	Periodicity int32  // number of periods in the next higher level, 0 for the highest level
+}
+
+// SetDateInfo declares the date structure of time series data for the trends
+// date information record, components go from the highest level to the lowest.
+// The variables are checked when the dictionary is written.
+func (out *SpssWriter) SetDateInfo(components ...DateComponent) error {
+	for i, c := range components {
+		if c.Periodicity < 0 || i > 0 && c.Periodicity == 0 {
+			return fmt.Errorf("invalid periodicity %d for %s", c.Periodicity, c.Var)
+		}
+		if c.Periodicity > 0 && (c.Start < 1 || c.Start > c.Periodicity) {
+			return fmt.Errorf("start %d of %s is not within the periodicity %d", c.Start, c.Var, c.Periodicity)
+		}
+	}
+
+	out.dateInfo = components
+	return nil
+}
+
+// checkDateInfo returns an error for date components that are not numeric variables
+func (out *SpssWriter) checkDateInfo() error {
+	for _, c := range out.dateInfo {
+		v, found := out.DictMap[c.Var]
+		if !found {
+			return fmt.Errorf("unknown variable %s in the date information", c.Var)
+		}
+		if v.TypeSize > 0 {
+			return fmt.Errorf("date information variable %s is not numeric", c.Var)
+		}
+	}
+
+	return nil
+}
+
+func (out *SpssWriter) dateInfoRecord() error {
+	if len(out.dateInfo) == 0 {
+		return nil
+	}
+
+	if err := binary.Write(out, endian, int32(7)); err != nil { // rec_type
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(6)); err != nil { // subtype
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(4)); err != nil { // size
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(len(out.dateInfo)*3)); err != nil { // count
+		return err
+	}
+
+	for _, c := range out.dateInfo {
+		if err := binary.Write(out, endian, []int32{out.DictMap[c.Var].Index, c.Start, c.Periodicity}); err != nil { // index, start, periodicity
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,208 @@
+package sav
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Encoding is the character encoding of the names, labels and string values
+// written to a file. Besides UTF-8 the single byte code pages that SPSS
+// supports in locale mode are available.
+type Encoding struct {
+	Name     string // name in the character encoding record
+	CodePage int32  // character code in the machine integer info record
+	high     []rune // characters for the bytes 0x80 to 0xff, nil for UTF-8
+	encode   map[rune]byte
+}
+
+var (
+	EncodingUTF8        = &Encoding{Name: "UTF-8", CodePage: 65001}
+	EncodingWindows1251 = newEncoding("windows-1251", 1251, windows1251)
+	EncodingWindows1252 = newEncoding("windows-1252", 1252, windows1252)
+	EncodingISO8859_1   = newEncoding("ISO-8859-1", 28591, latin1High())
+	EncodingISO8859_2   = newEncoding("ISO-8859-2", 28592, iso8859_2)
+	EncodingISO8859_5   = newEncoding("ISO-8859-5", 28595, iso8859_5())
+	EncodingISO8859_15  = newEncoding("ISO-8859-15", 28605, iso8859_15())
+
+	encodings = []*Encoding{
+		EncodingUTF8, EncodingWindows1251, EncodingWindows1252,
+		EncodingISO8859_1, EncodingISO8859_2, EncodingISO8859_5, EncodingISO8859_15,
+	}
+)
+
+// EncodingByName returns the encoding with the given name, ignoring case
+func EncodingByName(name string) (*Encoding, error) {
+	for _, e := range encodings {
+		if strings.EqualFold(e.Name, name) {
+			return e, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown encoding %s", name)
+}
+
+func newEncoding(name string, codePage int32, high []rune) *Encoding {
+	e := &Encoding{Name: name, CodePage: codePage, high: high, encode: make(map[rune]byte, len(high))}
+	for i, r := range high {
+		if r != 0 {
+			e.encode[r] = byte(0x80 + i)
+		}
+	}
+
+	return e
+}
+
+// singleByte reports whether every character is encoded in one byte
+func (e *Encoding) singleByte() bool {
+	return e != nil && e.high != nil
+}
+
+// Encode converts the UTF-8 string s to the encoding. Characters that can
+// not be represented are replaced by '?' and returned.
+func (e *Encoding) Encode(s string) (string, []rune) {
+	if !e.singleByte() {
+		return s, nil
+	}
+
+	var invalid []rune
+	buf := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch b, found := e.encode[r]; {
+		case r < utf8.RuneSelf:
+			buf = append(buf, byte(r))
+		case found:
+			buf = append(buf, b)
+		default:
+			buf = append(buf, '?')
+			invalid = append(invalid, r)
+		}
+	}
+
+	return string(buf), invalid
+}
+
+// strip removes the characters of s that can not be represented
+func (e *Encoding) strip(s string) string {
+	if !e.singleByte() {
+		return s
+	}
+
+	return strings.Map(func(r rune) rune {
+		if _, found := e.encode[r]; r >= utf8.RuneSelf && !found {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// encodeText converts dictionary text of a variable (empty for the file) to the
+// output encoding
+func (out *SpssWriter) encodeText(varName, s string) string {
+	return out.encodeValue(varName, s, -1)
+}
+
+// encodeValue converts s to the output encoding, characters that can not be
+// represented are reported with a warning for the case (-1 for the dictionary)
+func (out *SpssWriter) encodeValue(varName, s string, c int64) string {
+	encoded, invalid := out.Encoding.Encode(s)
+	if len(invalid) > 0 {
+		out.warn(Warning{
+			Kind:   WarningUnencodable,
+			Var:    varName,
+			Case:   c,
+			Value:  s,
+			Length: len(s),
+			Err:    fmt.Errorf("characters %q can not be represented in %s", string(invalid), out.Encoding.Name),
+		})
+	}
+
+	return encoded
+}
+
+// truncate cuts an encoded string to n bytes, keeping UTF-8 runes whole
+func (out *SpssWriter) truncate(s string, n int) string {
+	if out.Encoding.singleByte() {
+		if len(s) > n {
+			return s[:n]
+		}
+		return s
+	}
+
+	return truncateUTF8(s, n)
+}
+
+func latin1High() []rune {
+	high := make([]rune, 128)
+	for i := range high {
+		high[i] = rune(0x80 + i)
+	}
+	return high
+}
+
+var windows1252 = []rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021, 0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014, 0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+	0x00a0, 0x00a1, 0x00a2, 0x00a3, 0x00a4, 0x00a5, 0x00a6, 0x00a7, 0x00a8, 0x00a9, 0x00aa, 0x00ab, 0x00ac, 0x00ad, 0x00ae, 0x00af,
+	0x00b0, 0x00b1, 0x00b2, 0x00b3, 0x00b4, 0x00b5, 0x00b6, 0x00b7, 0x00b8, 0x00b9, 0x00ba, 0x00bb, 0x00bc, 0x00bd, 0x00be, 0x00bf,
+	0x00c0, 0x00c1, 0x00c2, 0x00c3, 0x00c4, 0x00c5, 0x00c6, 0x00c7, 0x00c8, 0x00c9, 0x00ca, 0x00cb, 0x00cc, 0x00cd, 0x00ce, 0x00cf,
+	0x00d0, 0x00d1, 0x00d2, 0x00d3, 0x00d4, 0x00d5, 0x00d6, 0x00d7, 0x00d8, 0x00d9, 0x00da, 0x00db, 0x00dc, 0x00dd, 0x00de, 0x00df,
+	0x00e0, 0x00e1, 0x00e2, 0x00e3, 0x00e4, 0x00e5, 0x00e6, 0x00e7, 0x00e8, 0x00e9, 0x00ea, 0x00eb, 0x00ec, 0x00ed, 0x00ee, 0x00ef,
+	0x00f0, 0x00f1, 0x00f2, 0x00f3, 0x00f4, 0x00f5, 0x00f6, 0x00f7, 0x00f8, 0x00f9, 0x00fa, 0x00fb, 0x00fc, 0x00fd, 0x00fe, 0x00ff,
+}
+
+var windows1251 = []rune{
+	0x0402, 0x0403, 0x201a, 0x0453, 0x201e, 0x2026, 0x2020, 0x2021, 0x20ac, 0x2030, 0x0409, 0x2039, 0x040a, 0x040c, 0x040b, 0x040f,
+	0x0452, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014, 0, 0x2122, 0x0459, 0x203a, 0x045a, 0x045c, 0x045b, 0x045f,
+	0x00a0, 0x040e, 0x045e, 0x0408, 0x00a4, 0x0490, 0x00a6, 0x00a7, 0x0401, 0x00a9, 0x0404, 0x00ab, 0x00ac, 0x00ad, 0x00ae, 0x0407,
+	0x00b0, 0x00b1, 0x0406, 0x0456, 0x0491, 0x00b5, 0x00b6, 0x00b7, 0x0451, 0x2116, 0x0454, 0x00bb, 0x0458, 0x0405, 0x0455, 0x0457,
+	0x0410, 0x0411, 0x0412, 0x0413, 0x0414, 0x0415, 0x0416, 0x0417, 0x0418, 0x0419, 0x041a, 0x041b, 0x041c, 0x041d, 0x041e, 0x041f,
+	0x0420, 0x0421, 0x0422, 0x0423, 0x0424, 0x0425, 0x0426, 0x0427, 0x0428, 0x0429, 0x042a, 0x042b, 0x042c, 0x042d, 0x042e, 0x042f,
+	0x0430, 0x0431, 0x0432, 0x0433, 0x0434, 0x0435, 0x0436, 0x0437, 0x0438, 0x0439, 0x043a, 0x043b, 0x043c, 0x043d, 0x043e, 0x043f,
+	0x0440, 0x0441, 0x0442, 0x0443, 0x0444, 0x0445, 0x0446, 0x0447, 0x0448, 0x0449, 0x044a, 0x044b, 0x044c, 0x044d, 0x044e, 0x044f,
+}
+
+var iso8859_2 = []rune{
+	0x0080, 0x0081, 0x0082, 0x0083, 0x0084, 0x0085, 0x0086, 0x0087, 0x0088, 0x0089, 0x008a, 0x008b, 0x008c, 0x008d, 0x008e, 0x008f,
+	0x0090, 0x0091, 0x0092, 0x0093, 0x0094, 0x0095, 0x0096, 0x0097, 0x0098, 0x0099, 0x009a, 0x009b, 0x009c, 0x009d, 0x009e, 0x009f,
+	0x00a0, 0x0104, 0x02d8, 0x0141, 0x00a4, 0x013d, 0x015a, 0x00a7, 0x00a8, 0x0160, 0x015e, 0x0164, 0x0179, 0x00ad, 0x017d, 0x017b,
+	0x00b0, 0x0105, 0x02db, 0x0142, 0x00b4, 0x013e, 0x015b, 0x02c7, 0x00b8, 0x0161, 0x015f, 0x0165, 0x017a, 0x02dd, 0x017e, 0x017c,
+	0x0154, 0x00c1, 0x00c2, 0x0102, 0x00c4, 0x0139, 0x0106, 0x00c7, 0x010c, 0x00c9, 0x0118, 0x00cb, 0x011a, 0x00cd, 0x00ce, 0x010e,
+	0x0110, 0x0143, 0x0147, 0x00d3, 0x00d4, 0x0150, 0x00d6, 0x00d7, 0x0158, 0x016e, 0x00da, 0x0170, 0x00dc, 0x00dd, 0x0162, 0x00df,
+	0x0155, 0x00e1, 0x00e2, 0x0103, 0x00e4, 0x013a, 0x0107, 0x00e7, 0x010d, 0x00e9, 0x0119, 0x00eb, 0x011b, 0x00ed, 0x00ee, 0x010f,
+	0x0111, 0x0144, 0x0148, 0x00f3, 0x00f4, 0x0151, 0x00f6, 0x00f7, 0x0159, 0x016f, 0x00fa, 0x0171, 0x00fc, 0x00fd, 0x0163, 0x02d9,
+}
+
+// iso8859_5 is Latin-1 in 0x80-0xa0 and 0xad, Cyrillic for the rest
+func iso8859_5() []rune {
+	high := latin1High()
+	for b := 0xa1; b <= 0xff; b++ {
+		high[b-0x80] = rune(0x0401 + b - 0xa1)
+	}
+	high[0xad-0x80] = 0x00ad
+	high[0xf0-0x80] = 0x2116
+	high[0xfd-0x80] = 0x00a7
+
+	return high
+}
+
+// iso8859_15 is Latin-1 with the euro sign and some French and Finnish letters
+func iso8859_15() []rune {
+	high := latin1High()
+	for b, r := range map[int]rune{
+		0xa4: 0x20ac, 0xa6: 0x0160, 0xa8: 0x0161, 0xb4: 0x017d,
+		0xb8: 0x017e, 0xbc: 0x0152, 0xbd: 0x0153, 0xbe: 0x0178,
+	} {
+		high[b-0x80] = r
+	}
+
+	return high
+}
+
+// encoding returns the output encoding, UTF-8 when none is set
+func (out *SpssWriter) encoding() *Encoding {
+	if out.Encoding == nil {
+		return EncodingUTF8
+	}
+	return out.Encoding
+}
@@ -0,0 +1,80 @@
+package sav_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/librun/sav"
+)
+
+func TestEncodingWindows1251(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	width := 8
+	nv, err := sav.NewNativeSav(path, []sav.Dict{
+		{Name: "city", Label: "Город", Type: sav.DictTypeString, Width: &width},
+		{Name: "num", Type: sav.DictTypeNumeric, Labels: []sav.Label{{Value: "1", Desc: "Да"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings []sav.Warning
+	nv.Writer().Warn = func(w sav.Warning) { warnings = append(warnings, w) }
+	nv.Writer().Encoding = sav.EncodingWindows1251
+
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteVal([]sav.Val{{Name: "city", Value: "Москва"}, {Name: "num", Value: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteVal([]sav.Val{{Name: "city", Value: "東京"}, {Name: "num", Value: "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if got, wait := f.str(0, 0, 1), "\xcc\xee\xf1\xea\xe2\xe0"; got != wait {
+		t.Errorf("got %q wait %q", got, wait)
+	}
+	if got, wait := f.str(1, 0, 1), "??"; got != wait {
+		t.Errorf("got %q wait %q", got, wait)
+	}
+	if got, wait := f.Vars[0].Label, "\xc3\xee\xf0\xee\xe4"; got != wait {
+		t.Errorf("got label %q wait %q", got, wait)
+	}
+	if got, wait := f.Labels[0].Descs[0], "\xc4\xe0"; got != wait {
+		t.Errorf("got value label %q wait %q", got, wait)
+	}
+	if got := string(f.Ext[20]); got != "windows-1251" {
+		t.Errorf("got encoding %q wait %q", got, "windows-1251")
+	}
+	if got := int32(binary.LittleEndian.Uint32(f.Ext[3][28:])); got != 1251 {
+		t.Errorf("got character code %d wait %d", got, 1251)
+	}
+
+	if len(warnings) != 1 {
+		t.Fatalf("got warnings %+v", warnings)
+	}
+	if w := warnings[0]; w.Kind != sav.WarningUnencodable || w.Var != "city" || w.Case != 1 || w.Value != "東京" {
+		t.Errorf("unexpected warning %+v", w)
+	}
+}
+
+func TestEncodingByName(t *testing.T) {
+	e, err := sav.EncodingByName("iso-8859-15")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, invalid := e.Encode("€ café"); got != "\xa4 caf\xe9" || len(invalid) != 0 {
+		t.Errorf("got %q %q", got, invalid)
+	}
+
+	if _, err := sav.EncodingByName("koi8-r"); err == nil {
+		t.Error("wait error for unknown encoding")
+	}
+}
@@ -0,0 +1,410 @@
+package sav
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// encryptedHeader starts an encrypted file, the encrypted system file follows it
+var encryptedHeader = []byte("\x1c\x00\x00\x00\x00\x00\x00\x00ENCRYPTEDSAV\x15\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+
+// maxPasswordLength is the longest password SPSS accepts
+const maxPasswordLength = 10
+
+// ErrWrongPassword is returned when an encrypted file does not decrypt to a system file
+var ErrWrongPassword = errors.New("wrong password for encrypted sav file")
+
+// passwordKeyFixed is the message authenticated with the password to derive the key
+var passwordKeyFixed = []byte{
+	0x00, 0x00, 0x00, 0x01, 0x35, 0x27, 0x13, 0xcc, 0x53, 0xa7, 0x78, 0x89,
+	0x87, 0x53, 0x22, 0x11, 0xd6, 0x5b, 0x31, 0x58, 0xdc, 0xfe, 0x2e, 0x7e,
+	0x94, 0xda, 0x2f, 0x00, 0xcc, 0x15, 0x71, 0x80, 0x0a, 0x6c, 0x63, 0x53,
+	0x00, 0x38, 0xc3, 0x38, 0xac, 0x22, 0xf3, 0x63, 0x62, 0x0e, 0xce, 0x85,
+	0x3f, 0xb8, 0x07, 0x4c, 0x4e, 0x2b, 0x77, 0xc7, 0x21, 0xf5, 0x1a, 0x80,
+	0x1d, 0x67, 0xfb, 0xe1, 0xe1, 0x83, 0x07, 0xd8, 0x0d, 0x00, 0x00, 0x01,
+	0x00,
+}
+
+// passwordCipher returns the AES-256 cipher of a password: the CMAC of the
+// fixed message keyed with the zero padded password, used twice as the key
+func passwordCipher(password string) (cipher.Block, error) {
+	if len(password) > maxPasswordLength {
+		return nil, fmt.Errorf("password is longer than %d bytes", maxPasswordLength)
+	}
+
+	padded := make([]byte, 32)
+	copy(padded, password)
+	block, err := aes.NewCipher(padded)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := cmac(block, passwordKeyFixed)
+	return aes.NewCipher(append(mac, mac...))
+}
+
+// cmac returns the AES-CMAC of msg (RFC 4493)
+func cmac(block cipher.Block, msg []byte) []byte {
+	const size = aes.BlockSize
+	subkey := func(in []byte) []byte {
+		out := make([]byte, size)
+		for i := 0; i < size; i++ {
+			out[i] = in[i] << 1
+			if i+1 < size {
+				out[i] |= in[i+1] >> 7
+			}
+		}
+		if in[0]&0x80 != 0 {
+			out[size-1] ^= 0x87
+		}
+		return out
+	}
+
+	l := make([]byte, size)
+	block.Encrypt(l, l)
+	k1 := subkey(l)
+	k2 := subkey(k1)
+
+	n := (len(msg) + size - 1) / size
+	last := make([]byte, size)
+	if n > 0 && len(msg)%size == 0 {
+		copy(last, msg[(n-1)*size:])
+		xorBytes(last, k1)
+	} else {
+		if n == 0 {
+			n = 1
+		}
+		rest := msg[(n-1)*size:]
+		copy(last, rest)
+		last[len(rest)] = 0x80
+		xorBytes(last, k2)
+	}
+
+	x := make([]byte, size)
+	for i := 0; i < n-1; i++ {
+		xorBytes(x, msg[i*size:(i+1)*size])
+		block.Encrypt(x, x)
+	}
+	xorBytes(x, last)
+	block.Encrypt(x, x)
+
+	return x
+}
+
+func xorBytes(dst, src []byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// EncryptSav writes the system file read from src encrypted with password to
+// dst, in the format of SPSS 21 and later: a 36 byte header followed by the
+// file encrypted with AES-256 in ECB mode and padded to whole blocks.
+func EncryptSav(dst io.Writer, src io.Reader, password string) error {
+	block, err := passwordCipher(password)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(dst)
+	if _, err := w.Write(encryptedHeader); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(src)
+	buf := make([]byte, aes.BlockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			pad := byte(aes.BlockSize - n) // 1 to 16 bytes of the pad length
+			for i := n; i < aes.BlockSize; i++ {
+				buf[i] = pad
+			}
+			block.Encrypt(buf, buf)
+			if _, err := w.Write(buf); err != nil {
+				return err
+			}
+			return w.Flush()
+		}
+		if err != nil {
+			return err
+		}
+
+		block.Encrypt(buf, buf)
+		if _, err := w.Write(buf); err != nil {
+			return err
+		}
+	}
+}
+
+// DecryptSav writes the system file in the encrypted file read from src to dst.
+// It returns ErrWrongPassword when the file does not decrypt to a system file.
+func DecryptSav(dst io.Writer, src io.Reader, password string) error {
+	r, err := NewDecryptReader(src, password)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, r)
+	return err
+}
+
+// decryptReader decrypts an encrypted file block by block, holding back the
+// last block to remove the padding
+type decryptReader struct {
+	r     *bufio.Reader
+	block cipher.Block
+	plain []byte // decrypted bytes not yet read
+	next  []byte // decrypted block that may be the last one
+	eof   bool
+}
+
+// NewDecryptReader returns a reader of the system file in an encrypted file,
+// it returns ErrWrongPassword when the first block is not a system file header
+func NewDecryptReader(src io.Reader, password string) (io.Reader, error) {
+	block, err := passwordCipher(password)
+	if err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(src)
+	header := make([]byte, len(encryptedHeader))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading encrypted header: %w", err)
+	}
+	if !bytes.Equal(header[8:20], encryptedHeader[8:20]) {
+		return nil, errors.New("not an encrypted sav file")
+	}
+
+	d := &decryptReader{r: r, block: block}
+	if err := d.fill(); err != nil {
+		return nil, err
+	}
+	if len(d.plain) < 4 || (string(d.plain[:4]) != "$FL2" && string(d.plain[:4]) != "$FL3") {
+		return nil, ErrWrongPassword
+	}
+
+	return d, nil
+}
+
+// fill decrypts the next block, removing the padding of the last one
+func (d *decryptReader) fill() error {
+	buf := make([]byte, aes.BlockSize)
+	_, err := io.ReadFull(d.r, buf)
+	if err == io.EOF {
+		d.eof = true
+		if len(d.next) == 0 {
+			return errors.New("encrypted sav file has no data")
+		}
+		pad := int(d.next[aes.BlockSize-1])
+		if pad < 1 || pad > aes.BlockSize {
+			return ErrWrongPassword
+		}
+		d.plain = append(d.plain, d.next[:aes.BlockSize-pad]...)
+		d.next = nil
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("encrypted sav file is not a multiple of %d bytes: %w", aes.BlockSize, err)
+	}
+
+	d.block.Decrypt(buf, buf)
+	d.plain = append(d.plain, d.next...)
+	d.next = buf
+	if len(d.plain) == 0 { // first block, read on to know whether it is the last
+		return d.fill()
+	}
+
+	return nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.eof {
+			return 0, io.EOF
+		}
+		if err := d.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// EncryptedWriter encrypts a system file while it is written, nothing is
+// stored in plain text. Use it as the io.WriteSeeker of a SpssWriter or
+// TypedWriter: the blocks rewritten by seeking back, like the number of cases
+// in the header, are decrypted from the output, changed and encrypted again.
+type EncryptedWriter struct {
+	w       io.ReadWriteSeeker
+	block   cipher.Block
+	base    int64  // output offset of the first encrypted block
+	pos     int64  // plain text offset of the next write
+	flushed int64  // plain text bytes written encrypted, a multiple of the block size
+	tail    []byte // plain text of the last, incomplete block
+	at      int64  // plain text offset of the output position
+}
+
+// NewEncryptedWriter writes the encrypted file header to w and returns a
+// writer encrypting with password, at most 10 bytes. Seeking back needs to
+// read the output, so w is an io.ReadWriteSeeker like an *os.File.
+func NewEncryptedWriter(w io.ReadWriteSeeker, password string) (*EncryptedWriter, error) {
+	block, err := passwordCipher(password)
+	if err != nil {
+		return nil, err
+	}
+
+	start, err := w.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(encryptedHeader); err != nil {
+		return nil, err
+	}
+
+	return &EncryptedWriter{w: w, block: block, base: start + int64(len(encryptedHeader))}, nil
+}
+
+func (e *EncryptedWriter) size() int64 {
+	return e.flushed + int64(len(e.tail))
+}
+
+func (e *EncryptedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		var n int
+		var err error
+		switch {
+		case e.pos >= e.flushed:
+			n, err = e.writeTail(p)
+		default:
+			n, err = e.rewriteBlock(p)
+		}
+		written += n
+		e.pos += int64(n)
+		p = p[n:]
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// writeTail writes into the incomplete last block, encrypting it when full.
+// Whole blocks at the end are encrypted at once.
+func (e *EncryptedWriter) writeTail(p []byte) (int, error) {
+	offset := int(e.pos - e.flushed)
+	if offset == 0 && len(e.tail) == 0 && len(p) >= aes.BlockSize {
+		n := len(p) - len(p)%aes.BlockSize
+		buf := make([]byte, n)
+		for i := 0; i < n; i += aes.BlockSize {
+			e.block.Encrypt(buf[i:i+aes.BlockSize], p[i:i+aes.BlockSize])
+		}
+		if err := e.writeAt(buf, e.flushed); err != nil {
+			return 0, err
+		}
+		e.flushed += int64(n)
+		return n, nil
+	}
+
+	n := aes.BlockSize - offset
+	if n > len(p) {
+		n = len(p)
+	}
+	if end := offset + n; end > len(e.tail) {
+		e.tail = append(e.tail, make([]byte, end-len(e.tail))...)
+	}
+	copy(e.tail[offset:], p[:n])
+
+	if len(e.tail) < aes.BlockSize {
+		return n, nil
+	}
+	buf := make([]byte, aes.BlockSize)
+	e.block.Encrypt(buf, e.tail)
+	if err := e.writeAt(buf, e.flushed); err != nil {
+		return 0, err
+	}
+	e.flushed += aes.BlockSize
+	e.tail = e.tail[:0]
+
+	return n, nil
+}
+
+// rewriteBlock changes an encrypted block at the current position
+func (e *EncryptedWriter) rewriteBlock(p []byte) (int, error) {
+	start := e.pos - e.pos%aes.BlockSize
+	buf := make([]byte, aes.BlockSize)
+	if _, err := e.w.Seek(e.base+start, io.SeekStart); err != nil {
+		return 0, err
+	}
+	e.at = -1
+	if _, err := io.ReadFull(e.w, buf); err != nil {
+		return 0, err
+	}
+
+	e.block.Decrypt(buf, buf)
+	n := copy(buf[e.pos-start:], p)
+	e.block.Encrypt(buf, buf)
+	if err := e.writeAt(buf, start); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// writeAt writes encrypted blocks for the plain text offset
+func (e *EncryptedWriter) writeAt(buf []byte, offset int64) error {
+	if e.at != offset {
+		if _, err := e.w.Seek(e.base+offset, io.SeekStart); err != nil {
+			e.at = -1
+			return err
+		}
+	}
+	n, err := e.w.Write(buf)
+	e.at = offset + int64(n)
+	return err
+}
+
+// Seek sets the plain text offset of the next write, within what is written
+func (e *EncryptedWriter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += e.pos
+	case io.SeekEnd:
+		offset += e.size()
+	}
+	if offset < 0 || offset > e.size() {
+		return e.pos, fmt.Errorf("seek to %d outside the %d bytes written", offset, e.size())
+	}
+	e.pos = offset
+
+	return offset, nil
+}
+
+// Close encrypts the padded last block, it does not close the underlying writer
+func (e *EncryptedWriter) Close() error {
+	pad := byte(aes.BlockSize - len(e.tail)) // 1 to 16 bytes of the pad length
+	buf := make([]byte, aes.BlockSize)
+	copy(buf, e.tail)
+	for i := len(e.tail); i < aes.BlockSize; i++ {
+		buf[i] = pad
+	}
+
+	e.block.Encrypt(buf, buf)
+	if err := e.writeAt(buf, e.flushed); err != nil {
+		return err
+	}
+	e.flushed += aes.BlockSize
+	e.tail = nil
+	e.pos = e.flushed
+
+	return nil
+}
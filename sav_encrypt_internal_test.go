@@ -0,0 +1,71 @@
+package sav
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// TestCMAC checks the examples of RFC 4493
+func TestCMAC(t *testing.T) {
+	block, err := aes.NewCipher(unhex(t, "2b7e151628aed2a6abf7158809cf4f3c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := unhex(t, "6bc1bee22e409f96e93d7e117393172a"+
+		"ae2d8a571e03ac9c9eb76fac45af8e51"+
+		"30c81c46a35ce411e5fbc1191a0a52ef"+
+		"f69f2445df4f9b17ad2b417be66c3710")
+
+	for _, test := range []struct {
+		length int
+		mac    string
+	}{
+		{0, "bb1d6929e95937287fa37d129b756746"},
+		{16, "070a16b46b4d4144f79bdd9dd04a287c"},
+		{40, "dfa66747de9ae63030ca32611497c827"},
+		{64, "51f0bebf7e3b9d92fc49741779363cfe"},
+	} {
+		if got := hex.EncodeToString(cmac(block, msg[:test.length])); got != test.mac {
+			t.Errorf("length %d: got %s wait %s", test.length, got, test.mac)
+		}
+	}
+}
+
+// TestEncryptVector pins the ciphertext of a known password and plain text
+func TestEncryptVector(t *testing.T) {
+	plain := []byte("$FL2@(#) SPSS DATA FILE")
+	want := "fac93f28d26060a0e48050b398f2bb996690ea4bfd0e3846a82f074747986a98"
+
+	var buf bytes.Buffer
+	if err := EncryptSav(&buf, bytes.NewReader(plain), "pspp"); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes()[:len(encryptedHeader)], encryptedHeader) {
+		t.Errorf("got header %q", buf.Bytes()[:len(encryptedHeader)])
+	}
+	if got := hex.EncodeToString(buf.Bytes()[len(encryptedHeader):]); got != want {
+		t.Errorf("got %s wait %s", got, want)
+	}
+
+	r, err := NewDecryptReader(bytes.NewReader(buf.Bytes()), "pspp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec bytes.Buffer
+	if _, err := dec.ReadFrom(r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(dec.Bytes(), plain) {
+		t.Errorf("got %q wait %q", dec.Bytes(), plain)
+	}
+}
@@ -0,0 +1,145 @@
+package sav_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librun/sav"
+)
+
+func TestEncryptedSav(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	width := 8
+	nv, err := sav.NewNativeSav(path, []sav.Dict{{Name: "name", Type: sav.DictTypeString, Width: &width}, {Name: "num"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.EnableEncryption("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteVal([]sav.Val{{Name: "name", Value: "Alice"}, {Name: "num", Value: "42"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path + ".sav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) < 36 || string(data[8:20]) != "ENCRYPTEDSAV" || (len(data)-36)%16 != 0 {
+		t.Fatalf("got no encrypted sav file: %q", data[:20])
+	}
+	if bytes.Contains(data, []byte("Alice")) {
+		t.Error("got plain text in encrypted file")
+	}
+
+	var plain bytes.Buffer
+	if err := sav.DecryptSav(&plain, bytes.NewReader(data), "secret"); err != nil {
+		t.Fatal(err)
+	}
+	f, err := parseSav(&plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.NCases != 1 || f.str(0, 0, 1) != "Alice" || f.num(0, 1) != 42 {
+		t.Errorf("got %d cases %q %v after decryption", f.NCases, f.str(0, 0, 1), f.num(0, 1))
+	}
+
+	if err := sav.DecryptSav(ioutil.Discard, bytes.NewReader(data), "wrong"); !errors.Is(err, sav.ErrWrongPassword) {
+		t.Errorf("got %v wait %v", err, sav.ErrWrongPassword)
+	}
+	if _, err := sav.NewEncryptedWriter(nil, "much too long"); err == nil {
+		t.Error("wait error for a password longer than 10 bytes")
+	}
+}
+
+func TestEncryptedWriterMatchesEncryptSav(t *testing.T) {
+	write := func(password string) []byte {
+		path, cleanup := tempSavPath(t)
+		defer cleanup()
+
+		width := 12
+		nv, err := sav.NewNativeSav(path, []sav.Dict{{Name: "name", Type: sav.DictTypeString, Width: &width}, {Name: "num"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		nv.FileLabel = "encrypted"
+		nv.Writer().Now = func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) }
+		if password != "" {
+			if err := nv.EnableEncryption(password); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := nv.WriteDict(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := nv.WriteVal([]sav.Val{{Name: "name", Value: strings.Repeat("x", i%13)}, {Name: "num", Value: strconv.Itoa(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := nv.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(path + ".sav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	var want bytes.Buffer
+	if err := sav.EncryptSav(&want, bytes.NewReader(write("")), "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if got := write("secret"); !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("got %d bytes different from the %d bytes of EncryptSav", len(got), want.Len())
+	}
+}
+
+func TestEncryptionWithSpool(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	nv, err := sav.NewNativeSav(path, []sav.Dict{{Name: "name", Type: sav.DictTypeString}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nv.EnableSpool()
+	if err := nv.EnableEncryption("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteDict(); err == nil {
+		t.Error("wait error for spooling an encrypted file")
+	}
+	nv.Close()
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	for _, size := range []int{4, 15, 16, 17, 100} {
+		src := append([]byte("$FL2"), bytes.Repeat([]byte{7}, size-4)...)
+		var enc, dec bytes.Buffer
+		if err := sav.EncryptSav(&enc, bytes.NewReader(src), "pw"); err != nil {
+			t.Fatal(err)
+		}
+		if err := sav.DecryptSav(&dec, &enc, "pw"); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(dec.Bytes(), src) {
+			t.Errorf("size %d: got %d bytes after round trip", size, dec.Len())
+		}
+	}
+}
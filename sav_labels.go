@@ -0,0 +1,78 @@
+package sav
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxLabelLength is the maximum length of a value label in bytes
+const maxLabelLength = 120
+
+// encodedLabel is a checked value label ready to be written
+type encodedLabel struct {
+	value  string  // encoded value of string variables
+	number float64 // value of numeric variables
+	desc   string  // encoded label of at most maxLabelLength bytes
+}
+
+// checkLabels encodes the value labels of v and reports every problem with a
+// WarningLabel: labels with a duplicate value, a value that is not a number
+// for a numeric variable or wider than a string variable are left out, longer
+// labels are truncated. Values of date variables are in the layouts of
+// WriteCase, or SPSS seconds.
+func (out *SpssWriter) checkLabels(v *Var, labels []Label) []encodedLabel {
+	checked := make([]encodedLabel, 0, len(labels))
+	seen := make(map[string]bool, len(labels))
+
+	for _, l := range labels {
+		var el encodedLabel
+		if v.TypeSize == 0 {
+			number, err := strconv.ParseFloat(strings.TrimSpace(l.Value), 64)
+			if v.isDate() {
+				if t, terr := v.parseTime(strings.TrimSpace(l.Value)); terr == nil {
+					number, err = t, nil
+				} else if err != nil {
+					out.warnLabel(v, l, fmt.Errorf("value is not a date: %w", terr))
+					continue
+				}
+			}
+			if err != nil {
+				out.warnLabel(v, l, fmt.Errorf("value is not a number"))
+				continue
+			}
+			el.number = number
+			key := strconv.FormatFloat(number, 'g', -1, 64)
+			if seen[key] {
+				out.warnLabel(v, l, fmt.Errorf("duplicate value"))
+				continue
+			}
+			seen[key] = true
+		} else {
+			el.value = out.encodeText(v.Name, l.Value)
+			if len(el.value) > int(v.TypeSize) {
+				out.warnLabel(v, l, fmt.Errorf("value is wider than the variable (%d bytes)", v.TypeSize))
+				continue
+			}
+			key := strings.TrimRight(el.value, " ")
+			if seen[key] {
+				out.warnLabel(v, l, fmt.Errorf("duplicate value"))
+				continue
+			}
+			seen[key] = true
+		}
+
+		el.desc = out.encodeText(v.Name, l.Desc)
+		if len(el.desc) > maxLabelLength {
+			out.warnLabel(v, l, fmt.Errorf("label is longer than %d bytes, it is truncated", maxLabelLength))
+			el.desc = out.truncate(el.desc, maxLabelLength)
+		}
+		checked = append(checked, el)
+	}
+
+	return checked
+}
+
+func (out *SpssWriter) warnLabel(v *Var, l Label, err error) {
+	out.warn(Warning{Kind: WarningLabel, Var: v.Name, Case: -1, Value: l.Value, Length: len(l.Desc), Err: err})
+}
@@ -0,0 +1,182 @@
+package sav
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// NamePolicy tells what Start does with variable names that are not valid in SPSS
+type NamePolicy int
+
+const (
+	// NamePolicyFix renames invalid variables to the suggested names and
+	// reports every rename with a WarningRenamed
+	NamePolicyFix NamePolicy = iota
+	// NamePolicyError makes Start fail with a *NameError
+	NamePolicyError
+	// NamePolicyAllow writes the names as given
+	NamePolicyAllow
+)
+
+// reservedWords can not be used as variable names
+var reservedWords = []string{"ALL", "AND", "BY", "EQ", "GE", "GT", "LE", "LT", "NE", "NOT", "OR", "TO", "WITH"}
+
+func isReservedWord(n string) bool {
+	for _, w := range reservedWords {
+		if strings.EqualFold(n, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// NameViolation is a problem with a variable name and the name that fixes it
+type NameViolation struct {
+	Name       string
+	Problem    string
+	Suggestion string
+}
+
+func (v NameViolation) String() string {
+	return fmt.Sprintf("variable name %q %s, use %q", v.Name, v.Problem, v.Suggestion)
+}
+
+// NameError is returned by Start for invalid names with NamePolicyError
+type NameError struct {
+	Violations []NameViolation
+}
+
+func (e *NameError) Error() string {
+	problems := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		problems[i] = v.String()
+	}
+	return "invalid variable names: " + strings.Join(problems, "; ")
+}
+
+// ValidateDict returns every problem with the variable names of dict
+func ValidateDict(dict []Dict) []NameViolation {
+	names := make([]string, len(dict))
+	for i, d := range dict {
+		names[i] = d.Name
+	}
+	violations, _ := validateNames(names, EncodingUTF8)
+	return violations
+}
+
+// Validate returns every problem with the names of the variables added so far,
+// including characters that can not be represented in the output encoding
+func (out *SpssWriter) Validate() []NameViolation {
+	violations, _ := validateNames(out.names(), out.encoding())
+	return violations
+}
+
+func (out *SpssWriter) names() []string {
+	names := make([]string, len(out.Dict))
+	for i, v := range out.Dict {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// applyNamePolicy checks the variable names before the dictionary is written
+func (out *SpssWriter) applyNamePolicy() error {
+	if out.NamePolicy == NamePolicyAllow {
+		return nil
+	}
+
+	violations, fixed := validateNames(out.names(), out.encoding())
+	if len(violations) == 0 {
+		return nil
+	}
+	if out.NamePolicy == NamePolicyError {
+		return &NameError{Violations: violations}
+	}
+
+	for i, v := range out.Dict {
+		if fixed[i] != v.Name {
+			out.warn(Warning{Kind: WarningRenamed, Var: fixed[i], Case: -1, Value: v.Name, Length: len(v.Name)})
+			v.Name = fixed[i]
+		}
+	}
+
+	return nil
+}
+
+// validateNames returns the problems of names and the names with the suggested
+// fixes in enc. Valid names are kept, so they are reserved before any suggestion.
+func validateNames(names []string, enc *Encoding) ([]NameViolation, []string) {
+	problems := make([][]string, len(names))
+	fixed := make([]string, len(names))
+	seen := make(map[string]string, len(names))  // lower case names to the first name
+	taken := make(map[string]string, len(names)) // lower case fixed names to their name
+
+	for i, n := range names {
+		problems[i] = nameProblems(n)
+		if _, invalid := enc.Encode(n); len(invalid) > 0 {
+			problems[i] = append(problems[i], fmt.Sprintf("has characters %q that can not be represented in %s", string(invalid), enc.Name))
+		}
+		if other, found := seen[strings.ToLower(n)]; found {
+			if other == n {
+				problems[i] = append(problems[i], "is a duplicate")
+			} else {
+				problems[i] = append(problems[i], fmt.Sprintf("differs only by case from %q", other))
+			}
+		} else {
+			seen[strings.ToLower(n)] = n
+		}
+
+		if len(problems[i]) == 0 {
+			fixed[i] = n
+			taken[strings.ToLower(n)] = n
+		}
+	}
+
+	var violations []NameViolation
+	for i, n := range names {
+		if len(problems[i]) == 0 {
+			continue
+		}
+
+		clean := cleanVarName(enc.strip(n))
+		fixed[i] = clean
+		for suffix := 2; taken[strings.ToLower(fixed[i])] != ""; suffix++ {
+			fixed[i] = fmt.Sprintf("%s_%d", truncateUTF8(clean, 60), suffix)
+		}
+		taken[strings.ToLower(fixed[i])] = n
+
+		for _, problem := range problems[i] {
+			violations = append(violations, NameViolation{Name: n, Problem: problem, Suggestion: fixed[i]})
+		}
+	}
+
+	return violations, fixed
+}
+
+// nameProblems describes why n is not a valid variable name
+func nameProblems(n string) []string {
+	if n == "" {
+		return []string{"is empty"}
+	}
+
+	var problems []string
+	if cleanVarNameRegExp.MatchString(strings.TrimPrefix(n, "@")) {
+		problems = append(problems, "contains invalid characters")
+	}
+	if first, _ := utf8.DecodeRuneInString(n); first != '@' && !unicode.IsLetter(first) {
+		problems = append(problems, "does not start with a letter")
+	}
+	if isReservedWord(n) {
+		problems = append(problems, "is a reserved word")
+	}
+	if strings.HasSuffix(n, ".") || strings.HasSuffix(n, "_") {
+		problems = append(problems, "ends with a period or underscore")
+	}
+	if len(n) > 64 {
+		problems = append(problems, "is longer than 64 bytes")
+	}
+
+	return problems
+}
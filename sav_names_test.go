@@ -0,0 +1,139 @@
+package sav_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/librun/sav"
+)
+
+func TestValidateDict(t *testing.T) {
+	violations := sav.ValidateDict([]sav.Dict{
+		{Name: "age"}, {Name: "AGE"}, {Name: "with"}, {Name: "total."}, {Name: "1st"}, {Name: "@ok"}, {Name: "straße"},
+	})
+
+	wait := map[string]string{
+		"AGE":    "AGE_2",
+		"with":   "@with",
+		"total.": "total",
+		"1st":    "@1st",
+	}
+	if len(violations) != len(wait) {
+		t.Fatalf("got %+v", violations)
+	}
+	for _, v := range violations {
+		if wait[v.Name] != v.Suggestion {
+			t.Errorf("got suggestion %q for %q wait %q (%s)", v.Suggestion, v.Name, wait[v.Name], v.Problem)
+		}
+	}
+}
+
+func TestValidateDictKeepsValidNames(t *testing.T) {
+	violations := sav.ValidateDict([]sav.Dict{{Name: "a b"}, {Name: "ab"}})
+	if len(violations) != 1 || violations[0].Name != "a b" || violations[0].Suggestion != "ab_2" {
+		t.Errorf("got %+v", violations)
+	}
+}
+
+func TestNamePolicy(t *testing.T) {
+	dict := []sav.Dict{{Name: "id", Type: sav.DictTypeNumeric}, {Name: "ID", Type: sav.DictTypeNumeric}, {Name: "by", Type: sav.DictTypeNumeric}}
+	cases := [][]sav.Val{{{Name: "id", Value: "1"}, {Name: "ID", Value: "2"}, {Name: "by", Value: "3"}}}
+
+	for _, test := range []struct {
+		policy sav.NamePolicy
+		names  string
+	}{
+		{sav.NamePolicyFix, "=id\t=ID_2\t=@by"},
+		{sav.NamePolicyAllow, "=id\t=ID\t=by"},
+		{sav.NamePolicyError, ""},
+	} {
+		path, cleanup := tempSavPath(t)
+
+		nv, err := sav.NewNativeSav(path, dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var warnings []sav.Warning
+		nv.Writer().Warn = func(w sav.Warning) { warnings = append(warnings, w) }
+		nv.Writer().NamePolicy = test.policy
+
+		err = nv.WriteDict()
+		if test.policy == sav.NamePolicyError {
+			var nameErr *sav.NameError
+			if !errors.As(err, &nameErr) || len(nameErr.Violations) != 2 {
+				t.Errorf("got %v wait name error", err)
+			}
+			nv.Close()
+			cleanup()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, vals := range cases {
+			if err := nv.WriteVal(vals); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := nv.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		f := readSavFile(t, path+".sav")
+		var names []string
+		for _, pair := range strings.Split(string(f.Ext[13]), "\t") {
+			names = append(names, pair[strings.Index(pair, "="):])
+		}
+		if got := strings.Join(names, "\t"); got != test.names {
+			t.Errorf("got names %q wait %q", got, test.names)
+		}
+		if got := f.num(0, 1); got != 2 {
+			t.Errorf("got %v wait 2", got)
+		}
+		if test.policy == sav.NamePolicyFix && (len(warnings) != 2 || warnings[0].Kind != sav.WarningRenamed) {
+			t.Errorf("got warnings %+v", warnings)
+		}
+		cleanup()
+	}
+}
+
+func TestUnencodableNames(t *testing.T) {
+	dict := []sav.Dict{{Name: "вопрос", Type: sav.DictTypeNumeric}, {Name: "café", Type: sav.DictTypeNumeric}}
+
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+	nv, err := sav.NewNativeSav(path, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nv.Writer().Encoding = sav.EncodingWindows1252
+	nv.Writer().NamePolicy = sav.NamePolicyError
+	var nameErr *sav.NameError
+	if err := nv.WriteDict(); !errors.As(err, &nameErr) || len(nameErr.Violations) != 1 || nameErr.Violations[0].Name != "вопрос" {
+		t.Errorf("got %v wait name error for вопрос", err)
+	}
+	nv.Close()
+
+	nv, err = sav.NewNativeSav(path, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []sav.Warning
+	nv.Writer().Warn = func(w sav.Warning) { warnings = append(warnings, w) }
+	nv.Writer().Encoding = sav.EncodingWindows1252
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if got := string(f.Ext[13]); strings.Contains(got, "?") || !strings.Contains(got, "=illegal\t") {
+		t.Errorf("got long names %q", got)
+	}
+	if len(warnings) != 1 || warnings[0].Kind != sav.WarningRenamed || warnings[0].Value != "вопрос" {
+		t.Errorf("got warnings %+v", warnings)
+	}
+}
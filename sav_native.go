@@ -1,6 +1,7 @@
 package sav
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -13,9 +14,20 @@ type (
 		Width    *int
 		Decimals *int
 		Measure  *string
-		Label    string
-		Default  *string
-		Labels   []Label
+		// Columns is the display width in Data View and Alignment is "left",
+		// "right" or "center", both default to the usual display of the type
+		Columns   *int
+		Alignment *string
+		Label     string
+		Default   *string
+		Labels    []Label
+		// LabelSet is shared with other entries, Labels override or add values
+		LabelSet *LabelSet
+		// NumberFormat overrides the number parsing of the writer for this variable
+		NumberFormat *NumberFormat
+		// TrueValues and FalseValues override the accepted spellings for DictTypeBool
+		TrueValues  []string
+		FalseValues []string
 	}
 	Val struct {
 		Name  string
@@ -27,29 +39,16 @@ type (
 		lengths  map[string]int
 		dict     []Dict
 		file     *os.File
+		spool    *spool           // cases waiting for the string widths, see EnableSpool
+		encrypt  *EncryptedWriter // see EnableEncryption
+		// FileLabel is the label in the file header, "start write value: " and
+		// the base name of the file when empty
+		FileLabel string
 	}
 )
 
 func GenerateNativeSav(filePath string, dict []Dict, cases [][]Val) error {
-	out, err := NewNativeSav(filePath, dict)
-	if err != nil {
-		return err
-	}
-
-	out.findLengths(cases)
-
-	// write dict
-	if err := out.WriteDict(); err != nil {
-		return err
-	}
-
-	for i := range cases {
-		if err := out.WriteVal(cases[i]); err != nil {
-			return err
-		}
-	}
-
-	return out.Close()
+	return GenerateNativeSavFromSource(filePath, dict, NewSliceSource(cases))
 }
 
 func NewNativeSav(filePath string, dict []Dict) (*NativeSav, error) {
@@ -71,87 +70,215 @@ func NewNativeSav(filePath string, dict []Dict) (*NativeSav, error) {
 	return &nv, nil
 }
 
+// Writer returns the underlying SpssWriter, options on it should be set before WriteDict
+func (nv *NativeSav) Writer() *SpssWriter {
+	return nv.out
+}
+
+// EnableSpool makes the widths of string variables without Width be discovered
+// while writing: WriteVal stores the cases in a temporary file and Close writes
+// the dictionary followed by the cases. Call it before WriteDict.
+func (nv *NativeSav) EnableSpool() {
+	nv.spool = &spool{}
+}
+
+// EnableEncryption makes the file be written encrypted with password, which
+// has at most 10 bytes. Call it before WriteDict. It can not be combined with
+// EnableSpool, which stores the cases in a temporary file.
+func (nv *NativeSav) EnableEncryption(password string) error {
+	enc, err := NewEncryptedWriter(nv.file, password)
+	if err != nil {
+		return err
+	}
+	nv.encrypt = enc
+	nv.out.setOutput(enc)
+
+	return nil
+}
+
+// Close writes what is left and closes the file, also when that fails
 func (nv *NativeSav) Close() error {
+	err := nv.finish()
+	if closeErr := nv.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Abort removes the spool and closes the file without writing what is left,
+// the incomplete file is not removed
+func (nv *NativeSav) Abort() error {
+	if nv.spool != nil {
+		nv.spool.remove()
+		nv.spool = nil
+	}
+
+	return nv.file.Close()
+}
+
+func (nv *NativeSav) finish() error {
+	if nv.spool != nil {
+		if err := nv.writeSpool(); err != nil {
+			return err
+		}
+	}
+
 	if err := nv.out.Finish(); err != nil {
 		return err
 	}
 
-	return nv.file.Close()
+	if nv.encrypt != nil {
+		return nv.encrypt.Close()
+	}
+
+	return nil
 }
 
-func (nv *NativeSav) WriteDict() (err error) {
+func (nv *NativeSav) WriteDict() error {
+	if nv.spool != nil && nv.encrypt != nil {
+		nv.spool = nil
+		return errors.New("spooled cases would be stored unencrypted, EnableSpool can not be used with EnableEncryption")
+	}
+
+	length := nv.getVarLength
+	if nv.spool != nil {
+		length = func(string) (int, error) { return maxStringLength, nil }
+	}
+
 	for _, d := range nv.dict {
-		v := new(Var)
-		v.Name = d.Name
-		v.Type = d.Type
-		v.TypeSize = SPSS_NUMERIC
-		v.Label = d.Label
-		v.Measure = SPSS_MLVL_NOM
-
-		switch d.Type {
-		case DictTypeNumeric:
-			v.Print = SPSS_FMT_F
-			v.Width = 8
-			v.Decimals = 2
-			if d.Width != nil {
-				v.Width = byte(*d.Width)
-			}
-			if d.Decimals != nil {
-				v.Decimals = byte(*d.Decimals)
-			}
-		case DictTypeDate:
-			v.Print = SPSS_FMT_DATE
-			v.Width = 11
-			v.Decimals = 0
-			v.Measure = SPSS_MLVL_RAT
-		case DictTypeDatetime:
-			v.Print = SPSS_FMT_DATE_TIME
-			v.Width = 20
-			v.Decimals = 0
-			v.Measure = SPSS_MLVL_RAT
-		default: // string
-			var width int
-			if d.Width != nil {
-				width = *d.Width
-			} else {
-				width, err = nv.getVarLength(v.Name)
-				if err != nil {
-					return err
-				}
-			}
-			v.TypeSize = int32(width)
-			v.Print = SPSS_FMT_A
-			v.Width = byte(width)
-			if width > 40 {
-				v.Width = 40
-			}
-			v.Decimals = 0
+		v, err := newVar(d, length)
+		if err != nil {
+			nv.spool = nil
+			return err
+		}
+		if nv.spool != nil && d.Type == DictTypeString && d.Width == nil {
+			nv.spool.auto = append(nv.spool.auto, v)
 		}
+		nv.out.AddVar(v)
+	}
 
-		if d.Default != nil {
-			v.HasDefault = true
-			v.Default = *d.Default
+	if nv.spool != nil {
+		if err := nv.out.prepare(); err != nil {
+			nv.spool = nil
+			return err
+		}
+		if err := nv.spool.open(); err != nil {
+			nv.spool.remove()
+			nv.spool = nil
+			return err
 		}
+		return nil
+	}
+
+	return nv.out.Start(nv.fileLabel())
+}
+
+func (nv *NativeSav) fileLabel() string {
+	if nv.FileLabel != "" {
+		return nv.FileLabel
+	}
+	return fmt.Sprintf("start write value: %s", nv.basename)
+}
 
-		if d.Measure != nil {
-			switch *d.Measure {
-			case "scale":
-				v.Measure = SPSS_MLVL_RAT
-			case "nominal":
-				v.Measure = SPSS_MLVL_NOM
-			case "ordinal":
-				v.Measure = SPSS_MLVL_ORD
-			default:
-				return fmt.Errorf("unknown value for measure %s", *d.Measure)
+// newVar creates the variable for a dictionary entry, length returns the
+// width of string variables without Width
+func newVar(d Dict, length func(name string) (int, error)) (v *Var, err error) {
+	v = new(Var)
+	v.Name = d.Name
+	v.Type = d.Type
+	v.TypeSize = SPSS_NUMERIC
+	v.Label = d.Label
+	v.Measure = SPSS_MLVL_NOM
+	v.NumberFormat = d.NumberFormat
+
+	switch d.Type {
+	case DictTypeNumeric:
+		v.Print = SPSS_FMT_F
+		v.Width = 8
+		v.Decimals = 2
+		if d.Width != nil {
+			v.Width = byte(*d.Width)
+		}
+		if d.Decimals != nil {
+			v.Decimals = byte(*d.Decimals)
+		}
+	case DictTypeBool:
+		v.Print = SPSS_FMT_F
+		v.Width = 1
+		v.Decimals = 0
+		v.TrueValues = d.TrueValues
+		v.FalseValues = d.FalseValues
+		if len(d.Labels) == 0 && d.LabelSet == nil {
+			v.Labels = []Label{{Value: "1", Desc: "True"}, {Value: "0", Desc: "False"}}
+		}
+	case DictTypeDate:
+		v.Print = SPSS_FMT_DATE
+		v.Width = 11
+		v.Decimals = 0
+		v.Measure = SPSS_MLVL_RAT
+	case DictTypeDatetime:
+		v.Print = SPSS_FMT_DATE_TIME
+		v.Width = 20
+		v.Decimals = 0
+		v.Measure = SPSS_MLVL_RAT
+	default: // string
+		var width int
+		if d.Width != nil {
+			width = *d.Width
+		} else {
+			width, err = length(v.Name)
+			if err != nil {
+				return nil, err
 			}
 		}
-		for _, l := range d.Labels {
-			v.Labels = append(v.Labels, Label{Value: l.Value, Desc: l.Desc})
+		v.Print = SPSS_FMT_A
+		v.setStringWidth(width)
+		v.Decimals = 0
+	}
+
+	if d.Default != nil {
+		v.HasDefault = true
+		v.Default = *d.Default
+	}
+
+	if d.Measure != nil {
+		switch *d.Measure {
+		case "scale":
+			v.Measure = SPSS_MLVL_RAT
+		case "nominal":
+			v.Measure = SPSS_MLVL_NOM
+		case "ordinal":
+			v.Measure = SPSS_MLVL_ORD
+		default:
+			return nil, fmt.Errorf("unknown value for measure %s", *d.Measure)
+		}
+	}
+	if d.Columns != nil {
+		if *d.Columns < 1 {
+			return nil, fmt.Errorf("invalid columns %d for %s", *d.Columns, d.Name)
+		}
+		v.Columns = int32(*d.Columns)
+	}
+	if d.Alignment != nil {
+		v.HasAlignment = true
+		switch *d.Alignment {
+		case "left":
+			v.Alignment = SPSS_ALIGN_LEFT
+		case "right":
+			v.Alignment = SPSS_ALIGN_RIGHT
+		case "center":
+			v.Alignment = SPSS_ALIGN_CENTER
+		default:
+			return nil, fmt.Errorf("unknown value for alignment %s", *d.Alignment)
 		}
-		nv.out.AddVar(v)
+	}
+	v.LabelSet = d.LabelSet
+	for _, l := range d.Labels {
+		v.Labels = append(v.Labels, Label{Value: l.Value, Desc: l.Desc})
 	}
 
-	return nv.out.Start(fmt.Sprintf("start write value: %s", nv.basename))
+	return v, nil
 }
 
 func (nv *NativeSav) WriteVal(vals []Val) error {
@@ -160,21 +287,41 @@ func (nv *NativeSav) WriteVal(vals []Val) error {
 		nv.out.SetVar(val.Name, val.Value)
 	}
 
+	if nv.spool != nil {
+		return nv.spool.writeCase(nv.out)
+	}
+
 	return nv.out.WriteCase()
 }
 
-func (nv *NativeSav) findLengths(cases [][]Val) {
-	for i := range cases {
-		for _, val := range cases[i] {
-			if _, ok := nv.lengths[val.Name]; !ok {
-				nv.lengths[val.Name] = len(val.Value)
+// setStringWidth sets the width of a string variable, at least 1
+func (v *Var) setStringWidth(width int) {
+	if width < 1 {
+		width = 1
+	}
+	v.TypeSize = int32(width)
+	v.Width = byte(width)
+	if width > 40 {
+		v.Width = 40
+	}
+}
 
-				continue
-			}
+// addLengths updates the string lengths with the values of a case
+func (nv *NativeSav) addLengths(vals []Val) {
+	for _, val := range vals {
+		l := len(val.Value) // the width is in bytes of UTF-8
+		if l > maxStringLength {
+			l = maxStringLength // longer values are truncated on a rune boundary
+		}
 
-			if nv.lengths[val.Name] < len(val.Value) {
-				nv.lengths[val.Name] = len(val.Value)
-			}
+		if _, ok := nv.lengths[val.Name]; !ok {
+			nv.lengths[val.Name] = l
+
+			continue
+		}
+
+		if nv.lengths[val.Name] < l {
+			nv.lengths[val.Name] = l
 		}
 	}
 }
@@ -1,10 +1,18 @@
 package sav_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"math"
+	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
 
 	"github.com/librun/sav"
 )
@@ -49,3 +57,656 @@ func TestCreateSavFileLongText(t *testing.T) {
 		log.Fatal(err)
 	}
 }
+
+func TestBoolVariable(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	err := sav.GenerateNativeSav(path, []sav.Dict{
+		{Name: "finished", Type: sav.DictTypeBool},
+		{Name: "agree", Type: sav.DictTypeBool, TrueValues: []string{"ja"}, FalseValues: []string{"nein"},
+			Labels: []sav.Label{{Value: "1", Desc: "Ja"}, {Value: "0", Desc: "Nein"}}},
+	}, [][]sav.Val{
+		{{Name: "finished", Value: "Yes"}, {Name: "agree", Value: "ja"}},
+		{{Name: "finished", Value: "false"}, {Name: "agree", Value: "nein"}},
+		{{Name: "finished", Value: "1"}, {Name: "agree", Value: "yes"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if f.Vars[0].Print != 5<<16|1<<8 {
+		t.Errorf("print format %x, wait F1.0", f.Vars[0].Print)
+	}
+
+	want := [][]float64{{1, 1}, {0, 0}, {1, -math.MaxFloat64}}
+	for c := range want {
+		for i, w := range want[c] {
+			if got := f.num(c, i); got != w {
+				t.Errorf("case %d var %d: got %v wait %v", c, i, got, w)
+			}
+		}
+	}
+
+	if len(f.Labels) != 2 || f.Labels[0].Descs[0] != "True" || f.Labels[1].Descs[0] != "Ja" {
+		t.Errorf("unexpected value labels %+v", f.Labels)
+	}
+}
+
+func TestTypedSetters(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	width := 4
+	nv, err := sav.NewNativeSav(path, []sav.Dict{
+		{Name: "num", Type: sav.DictTypeNumeric},
+		{Name: "day", Type: sav.DictTypeDate},
+		{Name: "str", Type: sav.DictTypeString, Width: &width},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// numbers set with SetNumber are not parsed with the writer's format
+	nv.Writer().NumberFormat = &sav.NumberFormat{DecimalSeparator: ","}
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+
+	out := nv.Writer()
+	day := time.Date(2007, 2, 4, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+	out.ClearCase()
+	out.SetNumber("num", 1234.5)
+	out.SetTime("day", day)
+	out.SetString("str", "abc")
+	if err := out.WriteCase(); err != nil {
+		t.Fatal(err)
+	}
+	out.ClearCase()
+	out.SetNumber("num", math.NaN())
+	out.SetTime("day", time.Time{})
+	out.SetMissing("str")
+	if err := out.WriteCase(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if f.num(0, 0) != 1234.5 || f.str(0, 2, 1) != "abc" {
+		t.Errorf("unexpected case %v", f.Cases[0])
+	}
+	if want := float64(time.Date(2007, 2, 4, 0, 0, 0, 0, time.UTC).Unix() + sav.TimeOffset); f.num(0, 1) != want {
+		t.Errorf("date: got %v wait %v", f.num(0, 1), want)
+	}
+	if f.num(1, 0) != -math.MaxFloat64 || f.num(1, 1) != -math.MaxFloat64 || f.str(1, 2, 1) != "" {
+		t.Errorf("expected missing values, got %v", f.Cases[1])
+	}
+}
+
+func TestSpool(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	nv, err := sav.NewNativeSav(path, []sav.Dict{
+		{Name: "short", Type: sav.DictTypeString},
+		{Name: "num", Type: sav.DictTypeNumeric},
+		{Name: "long", Type: sav.DictTypeString},
+		{Name: "empty", Type: sav.DictTypeString},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nv.EnableSpool()
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+
+	long := strings.Repeat("abcdefgh", 40) // 320 bytes, two segments
+	cases := [][]sav.Val{
+		{{Name: "short", Value: "ab"}, {Name: "num", Value: "1.5"}, {Name: "long", Value: "x"}},
+		{{Name: "short", Value: "abcdefghij"}, {Name: "long", Value: long}},
+	}
+	for _, c := range cases {
+		if err := nv.WriteVal(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if f.NCases != 2 || len(f.Cases) != 2 {
+		t.Fatalf("got %d/%d cases", f.NCases, len(f.Cases))
+	}
+	if f.Vars[0].Type != 10 || f.CaseSize != 2+1+32+9+1 {
+		t.Errorf("unexpected widths: short %d, case size %d", f.Vars[0].Type, f.CaseSize)
+	}
+	if f.str(0, 0, 2) != "ab" || f.num(0, 2) != 1.5 || f.str(0, 3, 1) != "x" {
+		t.Errorf("unexpected first case %v", f.Cases[0])
+	}
+	if f.str(1, 0, 2) != "abcdefghij" || f.num(1, 2) != -math.MaxFloat64 || f.str(1, 3, 32)+f.str(1, 35, 9) != long {
+		t.Errorf("unexpected second case %v", f.Cases[1])
+	}
+}
+
+func TestSpoolCloseWithoutDict(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	nv, err := sav.NewNativeSav(path, []sav.Dict{{Name: "ALL", Type: sav.DictTypeString}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nv.EnableSpool()
+	nv.Writer().NamePolicy = sav.NamePolicyError
+	if err := nv.WriteDict(); err == nil {
+		t.Fatal("expected a name error")
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	nv, err = sav.NewNativeSav(path, []sav.Dict{{Name: "text", Type: sav.DictTypeString}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nv.EnableSpool()
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTruncateUTF8(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	width := 5
+	nv, err := sav.NewNativeSav(path, []sav.Dict{
+		{Name: "text", Type: sav.DictTypeString, Width: &width},
+		{Name: "num", Type: sav.DictTypeNumeric},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings []sav.Warning
+	nv.Writer().Warn = func(w sav.Warning) { warnings = append(warnings, w) }
+
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteVal([]sav.Val{{Name: "text", Value: "abc"}, {Name: "num", Value: "1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteVal([]sav.Val{{Name: "text", Value: "привет"}, {Name: "num", Value: "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if got := f.str(1, 0, 1); got != "пр" {
+		t.Errorf("got %q wait %q", got, "пр")
+	}
+
+	if len(warnings) != 2 {
+		t.Fatalf("got warnings %+v", warnings)
+	}
+	if w := warnings[0]; w.Kind != sav.WarningTruncated || w.Var != "text" || w.Case != 1 || w.Length != 12 || w.Width != 5 {
+		t.Errorf("unexpected truncation warning %+v", w)
+	}
+	if w := warnings[1]; w.Kind != sav.WarningInvalid || w.Var != "num" || w.Value != "x" || w.Err == nil {
+		t.Errorf("unexpected invalid value warning %+v", w)
+	}
+}
+
+func TestUnicodeVarNames(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	long := strings.Repeat("я", 40)
+	names := []string{"город", "город_2", "straße", "вопрос1", long}
+	dict := make([]sav.Dict, len(names))
+	for i, n := range names {
+		dict[i] = sav.Dict{Name: n, Type: sav.DictTypeNumeric}
+	}
+	if err := sav.GenerateNativeSav(path, dict, [][]sav.Val{{{Name: "город", Value: "1"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	shorts := make(map[string]bool)
+	for _, v := range f.Vars {
+		if len(v.Name) == 0 || len(v.Name) > 8 || !utf8.ValidString(v.Name) || shorts[v.Name] {
+			t.Errorf("invalid short name %q", v.Name)
+		}
+		shorts[v.Name] = true
+	}
+
+	longNames := make(map[string]bool)
+	for _, pair := range strings.Split(string(f.Ext[13]), "\t") {
+		longNames[pair[strings.Index(pair, "=")+1:]] = true
+	}
+	for _, n := range names[:4] {
+		if !longNames[n] {
+			t.Errorf("long name %q missing in %q", n, f.Ext[13])
+		}
+	}
+	if truncated := strings.Repeat("я", 32); !longNames[truncated] {
+		t.Errorf("got %q wait truncated name %q", f.Ext[13], truncated)
+	}
+
+	if err := sav.CheckVarName("straße"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	var names []string
+	for i := 0; i < 40; i++ {
+		names = append(names, fmt.Sprintf("question_%d", i))
+	}
+
+	write := func() []byte {
+		dict := make([]sav.Dict, len(names))
+		vals := make([]sav.Val, len(names))
+		for i, n := range names {
+			dict[i] = sav.Dict{Name: n, Type: sav.DictTypeString}
+			vals[i] = sav.Val{Name: n, Value: strings.Repeat("x", i+1)}
+		}
+
+		nv, err := sav.NewNativeSav(path, dict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nv.Writer().Now = func() time.Time { return time.Date(2020, 1, 2, 15, 4, 5, 0, time.UTC) }
+		nv.EnableSpool()
+		if err := nv.WriteDict(); err != nil {
+			t.Fatal(err)
+		}
+		if err := nv.WriteVal(vals); err != nil {
+			t.Fatal(err)
+		}
+		if err := nv.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := ioutil.ReadFile(path + ".sav")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	first, second := write(), write()
+	if !bytes.Equal(first, second) {
+		t.Error("got different output for the same input")
+	}
+
+	f, err := parseSav(bytes.NewReader(first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Date != "02 Jan 20" || f.Time != "15:04:05" {
+		t.Errorf("got creation %q %q wait %q %q", f.Date, f.Time, "02 Jan 20", "15:04:05")
+	}
+}
+
+func TestFileLabelAndProduct(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	nv, err := sav.NewNativeSav(path, []sav.Dict{{Name: "num", Type: sav.DictTypeNumeric}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	nv.FileLabel = "Customer satisfaction 2026"
+	nv.Writer().ProductName = "survey-tool 1.2"
+	nv.Writer().ProductInfo = "Exported by survey-tool 1.2\nProject 42"
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if f.Label != nv.FileLabel {
+		t.Errorf("got label %q wait %q", f.Label, nv.FileLabel)
+	}
+	if wait := "@(#) SPSS DATA FILE - survey-tool 1.2"; f.ProdName != wait {
+		t.Errorf("got product %q wait %q", f.ProdName, wait)
+	}
+	if got := string(f.Ext[10]); got != nv.Writer().ProductInfo {
+		t.Errorf("got product info %q wait %q", got, nv.Writer().ProductInfo)
+	}
+}
+
+func TestDisplayColumnsAndAlignment(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	width, codeColumns, textColumns := 100, 4, 60
+	center, left := "center", "left"
+	nv, err := sav.NewNativeSav(path, []sav.Dict{
+		{Name: "code", Type: sav.DictTypeNumeric, Columns: &codeColumns, Alignment: &center},
+		{Name: "text", Type: sav.DictTypeString, Width: &width, Columns: &textColumns},
+		{Name: "num", Type: sav.DictTypeNumeric, Alignment: &left},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	var got []int32
+	for i := 0; i+4 <= len(f.Ext[11]); i += 4 {
+		got = append(got, int32(binary.LittleEndian.Uint32(f.Ext[11][i:])))
+	}
+	wait := []int32{1, 4, 2, 1, 60, 0, 1, 8, 0}
+	if fmt.Sprint(got) != fmt.Sprint(wait) {
+		t.Errorf("got display parameters %v wait %v", got, wait)
+	}
+
+	bad := "middle"
+	nv, err = sav.NewNativeSav(path, []sav.Dict{{Name: "x", Alignment: &bad}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nv.Close()
+	if err := nv.WriteDict(); err == nil {
+		t.Error("wait error for unknown alignment")
+	}
+}
+
+func TestSharedLabelSet(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	agree := &sav.LabelSet{Name: "agree", Labels: []sav.Label{
+		{Value: "1", Desc: "Disagree"}, {Value: "2", Desc: "Neutral"}, {Value: "3", Desc: "Agree"},
+	}}
+	dict := []sav.Dict{
+		{Name: "q1", Type: sav.DictTypeNumeric, LabelSet: agree},
+		{Name: "q2", Type: sav.DictTypeNumeric, LabelSet: agree, Labels: []sav.Label{{Value: "9", Desc: "No answer"}}},
+		{Name: "q3", Type: sav.DictTypeNumeric, LabelSet: agree},
+	}
+	if err := sav.GenerateNativeSav(path, dict, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if len(f.Labels) != 2 {
+		t.Fatalf("got %d label records wait 2", len(f.Labels))
+	}
+	if got := fmt.Sprint(f.Labels[0].Vars); got != "[1 3]" {
+		t.Errorf("got shared label variables %s wait [1 3]", got)
+	}
+	if got := fmt.Sprint(f.Labels[1].Descs); got != "[Disagree Neutral Agree No answer]" {
+		t.Errorf("got override labels %s", got)
+	}
+}
+
+func TestSharedLabelSetMembers(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	missing := &sav.LabelSet{Name: "missing", Labels: []sav.Label{{Value: "1-Jan-1900", Desc: "not applicable"}}}
+	codes := &sav.LabelSet{Name: "codes", Labels: []sav.Label{{Value: "ab", Desc: "short"}, {Value: "abcdef", Desc: "long"}}}
+	narrow, wide := 3, 8
+	dict := []sav.Dict{
+		{Name: "count", Type: sav.DictTypeNumeric, LabelSet: missing},
+		{Name: "born", Type: sav.DictTypeDate, LabelSet: missing},
+		{Name: "wide", Type: sav.DictTypeString, Width: &wide, LabelSet: codes},
+		{Name: "narrow", Type: sav.DictTypeString, Width: &narrow, LabelSet: codes},
+	}
+	nv, err := sav.NewNativeSav(path, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []sav.Warning
+	nv.Writer().Warn = func(w sav.Warning) { warnings = append(warnings, w) }
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if len(f.Labels) != 2 {
+		t.Fatalf("got %d label records wait 2", len(f.Labels))
+	}
+	sentinel := float64(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC).Unix() + sav.TimeOffset)
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(f.Labels[0].Values[0][:])); got != sentinel || fmt.Sprint(f.Labels[0].Vars) != "[2]" {
+		t.Errorf("got date label value %v for %v wait %v for [2]", got, f.Labels[0].Vars, sentinel)
+	}
+	if got := fmt.Sprint(f.Labels[1].Descs); got != "[short]" {
+		t.Errorf("got string labels %s wait [short]", got)
+	}
+	if len(warnings) != 2 || warnings[0].Var != "count" || warnings[1].Var != "narrow" || warnings[1].Value != "abcdef" {
+		t.Errorf("got warnings %+v", warnings)
+	}
+}
+
+func TestValueLabelValidation(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	width := 2
+	long := strings.Repeat("ж", 70)
+	nv, err := sav.NewNativeSav(path, []sav.Dict{
+		{Name: "num", Type: sav.DictTypeNumeric, Labels: []sav.Label{
+			{Value: "1.1", Desc: "one"}, {Value: "1.10", Desc: "again"}, {Value: "x", Desc: "text"}, {Value: "123456789", Desc: long},
+		}},
+		{Name: "code", Type: sav.DictTypeString, Width: &width, Labels: []sav.Label{{Value: "ab", Desc: "ok"}, {Value: "abc", Desc: "wide"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var warnings []sav.Warning
+	nv.Writer().Warn = func(w sav.Warning) { warnings = append(warnings, w) }
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(warnings) != 4 {
+		t.Fatalf("got warnings %v", warnings)
+	}
+	for i, value := range []string{"1.10", "x", "123456789", "abc"} {
+		if w := warnings[i]; w.Kind != sav.WarningLabel || w.Value != value || w.Case != -1 {
+			t.Errorf("unexpected warning %+v", w)
+		}
+	}
+
+	f := readSavFile(t, path+".sav")
+	labels := f.Labels[0]
+	if len(labels.Values) != 2 {
+		t.Fatalf("got %d labels wait 2", len(labels.Values))
+	}
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(labels.Values[0][:])); got != 1.1 {
+		t.Errorf("got value %v wait 1.1", got)
+	}
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(labels.Values[1][:])); got != 123456789 {
+		t.Errorf("got value %v wait 123456789", got)
+	}
+	if got, wait := labels.Descs[1], strings.Repeat("ж", 60); got != wait {
+		t.Errorf("got label %q wait %q", got, wait)
+	}
+}
+
+func TestDateValueLabels(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	dict := []sav.Dict{
+		{Name: "born", Type: sav.DictTypeDate, Labels: []sav.Label{{Value: "1-Jan-1900", Desc: "not applicable"}}},
+		{Name: "seen", Type: sav.DictTypeDatetime, Labels: []sav.Label{{Value: "1-Jan-1900 12:00:00", Desc: "unknown"}}},
+	}
+	if err := sav.GenerateNativeSav(path, dict, [][]sav.Val{{{Name: "born", Value: "1-Jan-1900"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	sentinel := float64(time.Date(1900, 1, 1, 0, 0, 0, 0, time.UTC).Unix() + sav.TimeOffset)
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(f.Labels[0].Values[0][:])); got != sentinel || got != f.num(0, 0) {
+		t.Errorf("got date label value %v wait %v", got, sentinel)
+	}
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(f.Labels[1].Values[0][:])); got != sentinel+12*3600 {
+		t.Errorf("got datetime label value %v wait %v", got, sentinel+12*3600)
+	}
+
+	// a set shared by a datetime and a date variable is parsed per format
+	missing := &sav.LabelSet{Name: "missing", Labels: []sav.Label{{Value: "1-Jan-1900", Desc: "not applicable"}}}
+	dict = []sav.Dict{
+		{Name: "dt", Type: sav.DictTypeDatetime, LabelSet: missing},
+		{Name: "d", Type: sav.DictTypeDate, LabelSet: missing},
+	}
+	if err := sav.GenerateNativeSav(path, dict, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	f = readSavFile(t, path+".sav")
+	if len(f.Labels) != 1 || fmt.Sprint(f.Labels[0].Vars) != "[2]" {
+		t.Fatalf("got label records %+v wait one for d", f.Labels)
+	}
+	if got := math.Float64frombits(binary.LittleEndian.Uint64(f.Labels[0].Values[0][:])); got != sentinel {
+		t.Errorf("got date label value %v wait %v", got, sentinel)
+	}
+}
+
+func TestVarSets(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	dict := []sav.Dict{{Name: "id"}, {Name: "q1"}, {Name: "q2"}, {Name: "age"}}
+	nv, err := sav.NewNativeSav(path, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Writer().AddVarSet("Section A", "q1", "q2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Writer().AddVarSet("Background", "id", "age"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Writer().AddVarSet("section a", "id"); err == nil {
+		t.Error("wait error for duplicate variable set")
+	}
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if got, wait := string(f.Ext[5]), "Section A= q1 q2\nBackground= id age\n"; got != wait {
+		t.Errorf("got %q wait %q", got, wait)
+	}
+
+	nv, err = sav.NewNativeSav(path, dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nv.Close()
+	if err := nv.Writer().AddVarSet("Broken", "q1", "q3"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteDict(); err == nil || !strings.Contains(err.Error(), "q3 in Broken") {
+		t.Errorf("got %v wait error for unknown variable", err)
+	}
+}
+
+func TestCaseCount64(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	for _, disable := range []bool{false, true} {
+		file, err := os.Create(path + ".sav")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := sav.NewSpssWriter(file)
+		out.DisableCaseCount64 = disable
+		out.AddVar(&sav.Var{Name: "num", Print: sav.SPSS_FMT_F, Width: 8, Decimals: 2})
+		if err := out.Start("count"); err != nil {
+			t.Fatal(err)
+		}
+		out.Count = math.MaxInt32 // as if that many cases were written
+		out.SetNumber("num", 1)
+		err = out.WriteCase()
+		if disable {
+			if err == nil {
+				t.Error("wait error for too many cases without the extended number of cases record")
+			}
+			file.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := out.Finish(); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		f := readSavFile(t, path+".sav")
+		if f.NCases != -1 {
+			t.Errorf("got header ncases %d wait -1", f.NCases)
+		}
+		if got := int64(binary.LittleEndian.Uint64(f.Ext[16][8:])); got != math.MaxInt32+1 {
+			t.Errorf("got ncases64 %d wait %d", got, int64(math.MaxInt32)+1)
+		}
+	}
+}
+
+func TestDateInfo(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	nv, err := sav.NewNativeSav(path, []sav.Dict{{Name: "sales"}, {Name: "YEAR_"}, {Name: "QUARTER_"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Writer().SetDateInfo(sav.DateComponent{Var: "QUARTER_", Start: 5, Periodicity: 4}); err == nil {
+		t.Error("wait error for start outside the periodicity")
+	}
+	if err := nv.Writer().SetDateInfo(
+		sav.DateComponent{Var: "YEAR_", Start: 2020},
+		sav.DateComponent{Var: "QUARTER_", Start: 3, Periodicity: 4},
+	); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.WriteDict(); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	var got []int32
+	for i := 0; i+4 <= len(f.Ext[6]); i += 4 {
+		got = append(got, int32(binary.LittleEndian.Uint32(f.Ext[6][i:])))
+	}
+	if wait := []int32{2, 2020, 0, 3, 3, 4}; fmt.Sprint(got) != fmt.Sprint(wait) {
+		t.Errorf("got date info %v wait %v", got, wait)
+	}
+}
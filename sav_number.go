@@ -0,0 +1,60 @@
+package sav
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NumberFormat describes how numeric text values are parsed before they are
+// written to the sav file. A nil *NumberFormat parses with strconv.ParseFloat.
+type NumberFormat struct {
+	DecimalSeparator  string   // decimal separator, "." when empty
+	GroupingSeparator string   // thousands separator removed before parsing
+	StripPercent      bool     // remove "%" signs, the value itself is not scaled
+	CurrencySymbols   []string // symbols removed before parsing, e.g. "€", "$", "EUR"
+}
+
+// Parse converts s to a float64 according to the format
+func (nf *NumberFormat) Parse(s string) (float64, error) {
+	if nf == nil {
+		return strconv.ParseFloat(s, 64)
+	}
+
+	n := strings.TrimSpace(s)
+	for _, c := range nf.CurrencySymbols {
+		if c != "" {
+			n = strings.ReplaceAll(n, c, "")
+		}
+	}
+
+	if nf.StripPercent {
+		n = strings.ReplaceAll(n, "%", "")
+	}
+
+	if nf.GroupingSeparator != "" {
+		n = strings.ReplaceAll(n, nf.GroupingSeparator, "")
+		if nf.GroupingSeparator == " " { // localized exports often use (narrow) no-break spaces
+			n = strings.ReplaceAll(n, "\u00a0", "")
+			n = strings.ReplaceAll(n, "\u202f", "")
+		}
+	}
+	n = strings.TrimSpace(n)
+
+	if nf.DecimalSeparator != "" && nf.DecimalSeparator != "." {
+		if strings.Contains(n, ".") {
+			return 0, fmt.Errorf("can not parse number %q: unexpected '.'", s)
+		}
+		if strings.Count(n, nf.DecimalSeparator) > 1 {
+			return 0, fmt.Errorf("can not parse number %q: more than one decimal separator", s)
+		}
+		n = strings.Replace(n, nf.DecimalSeparator, ".", 1)
+	}
+
+	f, err := strconv.ParseFloat(n, 64)
+	if err != nil {
+		return 0, fmt.Errorf("can not parse number %q: %w", s, err)
+	}
+
+	return f, nil
+}
@@ -0,0 +1,49 @@
+package sav_test
+
+import (
+	"testing"
+
+	"github.com/librun/sav"
+)
+
+func TestNumberFormatParse(t *testing.T) {
+	eu := &sav.NumberFormat{
+		DecimalSeparator:  ",",
+		GroupingSeparator: ".",
+		StripPercent:      true,
+		CurrencySymbols:   []string{"€", "EUR"},
+	}
+
+	tests := []struct {
+		nf    *sav.NumberFormat
+		value string
+		want  float64
+		err   bool
+	}{
+		{nil, "1234.56", 1234.56, false},
+		{nil, "1.234,56", 0, true},
+		{eu, "1.234,56", 1234.56, false},
+		{eu, "12,5", 12.5, false},
+		{eu, " 12,5 % ", 12.5, false},
+		{eu, "€ -1.000", -1000, false},
+		{eu, "3 EUR", 3, false},
+		{eu, "1,2,3", 0, true},
+		{&sav.NumberFormat{DecimalSeparator: ",", GroupingSeparator: " "}, "1 234 567,5", 1234567.5, false},
+		{&sav.NumberFormat{DecimalSeparator: ","}, "1.5", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.nf.Parse(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parse %q: expected error, got %v", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parse %q: %v", tt.value, err)
+		} else if got != tt.want {
+			t.Errorf("parse %q: got %v wait %v", tt.value, got, tt.want)
+		}
+	}
+}
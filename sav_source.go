@@ -0,0 +1,110 @@
+package sav
+
+import (
+	"errors"
+	"io"
+)
+
+// CaseSource iterates over cases, Next returns io.EOF after the last case
+type CaseSource interface {
+	Next() ([]Val, error)
+}
+
+// ReplayableCaseSource is a CaseSource that can start again at the first case
+type ReplayableCaseSource interface {
+	CaseSource
+	Reset() error
+}
+
+// SliceSource is a ReplayableCaseSource over cases in memory
+type SliceSource struct {
+	cases [][]Val
+	index int
+}
+
+// NewSliceSource returns a source for cases
+func NewSliceSource(cases [][]Val) *SliceSource {
+	return &SliceSource{cases: cases}
+}
+
+func (s *SliceSource) Next() ([]Val, error) {
+	if s.index >= len(s.cases) {
+		return nil, io.EOF
+	}
+	s.index++
+
+	return s.cases[s.index-1], nil
+}
+
+func (s *SliceSource) Reset() error {
+	s.index = 0
+	return nil
+}
+
+// GenerateNativeSavFromSource writes the cases of src to filePath + ".sav".
+//
+// The widths of string variables without Width are found in a first pass over
+// the cases of a ReplayableCaseSource, other sources are written with the
+// spool of NativeSav.EnableSpool.
+func GenerateNativeSavFromSource(filePath string, dict []Dict, src CaseSource) error {
+	out, err := NewNativeSav(filePath, dict)
+	if err != nil {
+		return err
+	}
+
+	if err := writeSource(out, dict, src); err != nil {
+		out.Abort()
+		return err
+	}
+
+	return out.Close()
+}
+
+// needsLengths reports whether there are string variables without Width
+func needsLengths(dict []Dict) bool {
+	for _, d := range dict {
+		if d.Type == DictTypeString && d.Width == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func forEachCase(src CaseSource, fn func([]Val) error) error {
+	for {
+		vals, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(vals); err != nil {
+			return err
+		}
+	}
+}
+
+func writeSource(out *NativeSav, dict []Dict, src CaseSource) error {
+	if needsLengths(dict) {
+		if replay, ok := src.(ReplayableCaseSource); ok {
+			if err := forEachCase(src, func(vals []Val) error {
+				out.addLengths(vals)
+				return nil
+			}); err != nil {
+				return err
+			}
+			if err := replay.Reset(); err != nil {
+				return err
+			}
+		} else {
+			out.EnableSpool()
+		}
+	}
+
+	if err := out.WriteDict(); err != nil {
+		return err
+	}
+
+	return forEachCase(src, out.WriteVal)
+}
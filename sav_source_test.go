@@ -0,0 +1,104 @@
+package sav_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/librun/sav"
+)
+
+// countingSource generates cases once, it can not be replayed
+type countingSource struct {
+	n, max int
+}
+
+func (s *countingSource) Next() ([]sav.Val, error) {
+	if s.n >= s.max {
+		return nil, io.EOF
+	}
+	s.n++
+
+	return []sav.Val{
+		{Name: "id", Value: strconv.Itoa(s.n)},
+		{Name: "text", Value: strings.Repeat("x", s.n)},
+	}, nil
+}
+
+// failingSource fails after its cases
+type failingSource struct {
+	countingSource
+}
+
+func (s *failingSource) Next() ([]sav.Val, error) {
+	if s.n >= s.max {
+		return nil, errors.New("source failed")
+	}
+	return s.countingSource.Next()
+}
+
+func TestGenerateNativeSavFromSource(t *testing.T) {
+	dict := []sav.Dict{
+		{Name: "id", Type: sav.DictTypeNumeric},
+		{Name: "text", Type: sav.DictTypeString},
+	}
+
+	for _, replayable := range []bool{false, true} {
+		path, cleanup := tempSavPath(t)
+
+		var src sav.CaseSource = &countingSource{max: 20}
+		if replayable {
+			var cases [][]sav.Val
+			for i := 1; i <= 20; i++ {
+				cases = append(cases, []sav.Val{
+					{Name: "id", Value: strconv.Itoa(i)},
+					{Name: "text", Value: strings.Repeat("x", i)},
+				})
+			}
+			src = sav.NewSliceSource(cases)
+		}
+
+		if err := sav.GenerateNativeSavFromSource(path, dict, src); err != nil {
+			t.Fatal(err)
+		}
+
+		f := readSavFile(t, path+".sav")
+		cleanup()
+		if len(f.Cases) != 20 || f.Vars[1].Type != 20 {
+			t.Fatalf("replayable %v: got %d cases and text width %d", replayable, len(f.Cases), f.Vars[1].Type)
+		}
+		for c := range f.Cases {
+			if f.num(c, 0) != float64(c+1) || f.str(c, 1, 3) != strings.Repeat("x", c+1) {
+				t.Errorf("replayable %v: unexpected case %d", replayable, c)
+			}
+		}
+	}
+}
+
+func TestGenerateNativeSavFromSourceError(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	dict := []sav.Dict{
+		{Name: "id", Type: sav.DictTypeNumeric},
+		{Name: "text", Type: sav.DictTypeString},
+	}
+	if err := sav.GenerateNativeSavFromSource(path, dict, &failingSource{countingSource{max: 2}}); err == nil {
+		t.Fatal("expected the error of the source")
+	}
+
+	files, err := ioutil.ReadDir(os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasPrefix(file.Name(), "sav-spool-") {
+			t.Errorf("spool %s was not removed", file.Name())
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package sav
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// spool stores encoded cases in a temporary file until the widths of the
+// string variables are known
+type spool struct {
+	file   *os.File
+	writer *bufio.Writer
+	auto   []*Var         // string variables without Width
+	widths map[*Var]int32 // longest value of the auto variables
+}
+
+func (s *spool) open() (err error) {
+	s.file, err = ioutil.TempFile("", "sav-spool-")
+	if err != nil {
+		return err
+	}
+	s.writer = bufio.NewWriter(s.file)
+	s.widths = make(map[*Var]int32, len(s.auto))
+
+	return nil
+}
+
+// remove deletes the temporary file, if it was created
+func (s *spool) remove() {
+	if s.file == nil {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+	s.file = nil
+}
+
+// writeCase encodes the current case of out: strings as their length and
+// bytes, numbers as a missing flag and the float64 bits
+func (s *spool) writeCase(out *SpssWriter) error {
+	if err := out.checkCaseCount(); err != nil {
+		return err
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	for _, v := range out.Dict {
+		str, number, ok := out.caseValue(v)
+		if v.TypeSize > 0 {
+			if int32(len(str)) > s.widths[v] {
+				s.widths[v] = int32(len(str))
+			}
+			if _, err := s.writer.Write(buf[:binary.PutUvarint(buf[:], uint64(len(str)))]); err != nil {
+				return err
+			}
+			if _, err := s.writer.WriteString(str); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !ok {
+			if err := s.writer.WriteByte(0); err != nil {
+				return err
+			}
+			continue
+		}
+		buf[0] = 1
+		endian.PutUint64(buf[1:9], math.Float64bits(number))
+		if _, err := s.writer.Write(buf[:9]); err != nil {
+			return err
+		}
+	}
+	out.Count++
+
+	return nil
+}
+
+// writeSpool writes the dictionary with the discovered string widths and
+// copies the spooled cases, padded to their final width
+func (nv *NativeSav) writeSpool() error {
+	s := nv.spool
+	nv.spool = nil
+	if s.file == nil { // WriteDict was not called, there is nothing to write
+		return nil
+	}
+	defer s.remove()
+
+	for _, v := range s.auto {
+		v.setStringWidth(int(s.widths[v]))
+	}
+	nv.out.relayout()
+
+	if err := nv.out.Start(nv.fileLabel()); err != nil {
+		return err
+	}
+
+	if err := s.writer.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(s.file)
+	var buf [8]byte
+	count := nv.out.Count
+	nv.out.Count = 0
+	for c := int64(0); c < count; c++ {
+		for _, v := range nv.out.Dict {
+			if v.TypeSize > 0 {
+				l, err := binary.ReadUvarint(r)
+				if err != nil {
+					return err
+				}
+				str := make([]byte, l)
+				if _, err := io.ReadFull(r, str); err != nil {
+					return err
+				}
+				if err := nv.out.writeValue(v, string(str), 0, true); err != nil {
+					return err
+				}
+				continue
+			}
+
+			flag, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if flag == 0 {
+				if err := nv.out.writeValue(v, "", 0, false); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return err
+			}
+			if err := nv.out.writeValue(v, "", math.Float64frombits(endian.Uint64(buf[:])), true); err != nil {
+				return err
+			}
+		}
+		nv.out.Count++
+	}
+
+	return nil
+}
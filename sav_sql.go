@@ -0,0 +1,216 @@
+package sav
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// SQLOptions configures WriteSQLRows
+type SQLOptions struct {
+	FileLabel   string // label in the file header
+	StringWidth int    // width of string columns of unknown length, 255 when zero
+	// Column is called with the derived dictionary entry of every column,
+	// it can change for example the name, label, measure or value labels.
+	// NULL is written as missing for every column, the nullability reported
+	// by ct.Nullable is not used for the derived entry.
+	Column func(ct *sql.ColumnType, d *Dict)
+	// Configure is called before the dictionary is written, it can set
+	// options of the writer like Encoding or Now
+	Configure func(out *SpssWriter)
+}
+
+// sqlTimeLayouts are tried for date and time columns returned as text
+var sqlTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// WriteSQLRows derives the dictionary from the column types of rows and writes
+// every row as a case to w, opts may be nil. Rows are streamed, NULL values are
+// written as missing. The rows are not closed.
+func WriteSQLRows(w io.WriteSeeker, rows *sql.Rows, opts *SQLOptions) error {
+	if opts == nil {
+		opts = &SQLOptions{}
+	}
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+
+	stringWidth := opts.StringWidth
+	if stringWidth <= 0 {
+		stringWidth = defaultTypedStringWidth
+	}
+	length := func(string) (int, error) { return stringWidth, nil }
+
+	out := NewSpssWriter(w)
+	dict := make([]Dict, len(columnTypes))
+	names := make(map[string]bool)
+	for i, ct := range columnTypes {
+		dict[i] = sqlColumnDict(ct)
+		if opts.Column != nil {
+			opts.Column(ct, &dict[i])
+		}
+
+		name := dict[i].Name
+		for n := 2; names[strings.ToLower(dict[i].Name)]; n++ { // e.g. a.id and b.id
+			dict[i].Name = fmt.Sprintf("%s_%d", name, n)
+		}
+		names[strings.ToLower(dict[i].Name)] = true
+
+		v, err := newVar(dict[i], length)
+		if err != nil {
+			return err
+		}
+		out.AddVar(v)
+	}
+
+	if opts.Configure != nil {
+		opts.Configure(out)
+	}
+	if err := out.Start(opts.FileLabel); err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columnTypes))
+	dest := make([]interface{}, len(columnTypes))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+
+		out.ClearCase()
+		for i, value := range values {
+			if err := setSQLValue(out, dict[i], value); err != nil {
+				return err
+			}
+		}
+		if err := out.WriteCase(); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	return out.Finish()
+}
+
+// sqlColumnDict derives the dictionary entry of a column from its database
+// type, ct.Nullable is ignored as NULL is always written as missing
+func sqlColumnDict(ct *sql.ColumnType) Dict {
+	d := Dict{Name: ct.Name(), NumberFormat: goNumberFormat}
+	zero := 0
+
+	typeName := strings.TrimPrefix(strings.ToUpper(ct.DatabaseTypeName()), "UNSIGNED ")
+	switch typeName {
+	case "INT", "INTEGER", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "INT2", "INT4", "INT8",
+		"SERIAL", "SMALLSERIAL", "BIGSERIAL", "YEAR":
+		d.Type = DictTypeNumeric
+		d.Decimals = &zero
+	case "DECIMAL", "NUMERIC", "NUMBER":
+		d.Type = DictTypeNumeric
+		if precision, scale, ok := ct.DecimalSize(); ok {
+			width, decimals := int(precision)+2, int(scale) // sign and decimal point
+			if scale == precision {
+				width++ // leading zero
+			}
+			if width > 40 {
+				width = 40
+			}
+			if decimals > 16 { // the most F formats allow
+				decimals = 16
+			}
+			if decimals > width-1 {
+				decimals = width - 1
+			}
+			d.Width = &width
+			d.Decimals = &decimals
+		}
+		scaleMeasure := "scale"
+		d.Measure = &scaleMeasure
+	case "FLOAT", "FLOAT4", "FLOAT8", "DOUBLE", "DOUBLE PRECISION", "REAL", "MONEY":
+		d.Type = DictTypeNumeric
+		scaleMeasure := "scale"
+		d.Measure = &scaleMeasure
+	case "BOOL", "BOOLEAN", "BIT":
+		d.Type = DictTypeBool
+		d.TrueValues = []string{"true", "t", "yes", "y", "1"}
+		d.FalseValues = []string{"false", "f", "no", "n", "0"}
+	case "DATE":
+		d.Type = DictTypeDate
+	case "DATETIME", "DATETIME2", "TIMESTAMP", "TIMESTAMPTZ", "SMALLDATETIME", "DATETIMEOFFSET":
+		d.Type = DictTypeDatetime
+	case "CHAR", "VARCHAR", "NCHAR", "NVARCHAR", "BPCHAR", "TEXT", "NTEXT", "TINYTEXT", "MEDIUMTEXT",
+		"LONGTEXT", "VARCHAR2", "NVARCHAR2", "UUID", "ENUM":
+		d.Type = DictTypeString
+	default:
+		d.Type = DictTypeString
+		if st := ct.ScanType(); st != nil {
+			if t, err := dictType(st); err == nil {
+				d.Type = t
+			}
+		}
+	}
+
+	if d.Type == DictTypeString {
+		if length, ok := ct.Length(); ok && length > 0 && length <= maxStringLength {
+			width := int(length)
+			d.Width = &width
+		}
+	}
+
+	return d
+}
+
+// setSQLValue sets a scanned driver value
+func setSQLValue(out *SpssWriter, d Dict, value interface{}) error {
+	switch value := value.(type) {
+	case nil:
+		out.SetMissing(d.Name)
+	case []byte:
+		if d.Type == DictTypeBool && len(value) == 1 && value[0] <= 1 { // MySQL BIT(1)
+			out.SetNumber(d.Name, float64(value[0]))
+			return nil
+		}
+		setSQLText(out, d, string(value))
+	case string:
+		setSQLText(out, d, value)
+	case time.Time:
+		if d.Type == DictTypeString {
+			out.SetString(d.Name, value.Format(time.RFC3339))
+		} else {
+			out.SetTime(d.Name, value)
+		}
+	default:
+		return setColumn(out, d, reflect.ValueOf(value))
+	}
+
+	return nil
+}
+
+// setSQLText sets a value returned as text, date and time columns are parsed
+// with the usual database layouts
+func setSQLText(out *SpssWriter, d Dict, s string) {
+	if d.Type == DictTypeDate || d.Type == DictTypeDatetime {
+		for _, layout := range sqlTimeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				out.SetTime(d.Name, t)
+				return
+			}
+		}
+	}
+
+	out.SetVar(d.Name, s) // for dates the text layouts of WriteCase
+}
@@ -0,0 +1,163 @@
+package sav_test
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/librun/sav"
+)
+
+// fakeDriver returns the rows of fakeRows for every query
+type fakeDriver struct{}
+
+type fakeColumn struct {
+	name, typeName   string
+	length           int64
+	precision        int64
+	scale            int64
+	decimalSizeKnown bool
+}
+
+var (
+	fakeColumns = []fakeColumn{
+		{name: "id", typeName: "BIGINT"},
+		{name: "name", typeName: "VARCHAR", length: 12},
+		{name: "amount", typeName: "DECIMAL", precision: 10, scale: 2, decimalSizeKnown: true},
+		{name: "active", typeName: "BOOL"},
+		{name: "born", typeName: "DATE"},
+		{name: "seen", typeName: "TIMESTAMP"},
+		{name: "id", typeName: "INT"},
+		{name: "flag", typeName: "BIT"},
+		{name: "ratio", typeName: "NUMERIC", precision: 5, scale: 5, decimalSizeKnown: true},
+		{name: "big", typeName: "DECIMAL", precision: 65, scale: 30, decimalSizeKnown: true},
+	}
+	fakeRows = [][]driver.Value{
+		{int64(1), "Ann", []byte("12.50"), true, []byte("1971-01-31"), time.Date(2009, 3, 5, 13, 13, 37, 0, time.UTC), int64(7), []byte{1}, []byte("-0.12345"), []byte("1.5")},
+		{int64(2), nil, nil, []byte("f"), nil, nil, nil, []byte{0}, nil, nil},
+	}
+)
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return 0 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return nil, driver.ErrSkip }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return &fakeRowsIter{}, nil }
+
+type fakeRowsIter struct{ i int }
+
+func (r *fakeRowsIter) Columns() []string {
+	names := make([]string, len(fakeColumns))
+	for i := range fakeColumns {
+		names[i] = fakeColumns[i].name
+	}
+	return names
+}
+
+func (r *fakeRowsIter) Close() error { return nil }
+
+func (r *fakeRowsIter) Next(dest []driver.Value) error {
+	if r.i >= len(fakeRows) {
+		return io.EOF
+	}
+	copy(dest, fakeRows[r.i])
+	r.i++
+	return nil
+}
+
+func (r *fakeRowsIter) ColumnTypeDatabaseTypeName(i int) string { return fakeColumns[i].typeName }
+
+func (r *fakeRowsIter) ColumnTypeLength(i int) (int64, bool) {
+	return fakeColumns[i].length, fakeColumns[i].length > 0
+}
+
+func (r *fakeRowsIter) ColumnTypePrecisionScale(i int) (int64, int64, bool) {
+	return fakeColumns[i].precision, fakeColumns[i].scale, fakeColumns[i].decimalSizeKnown
+}
+
+func (r *fakeRowsIter) ColumnTypeNullable(i int) (bool, bool) {
+	return fakeColumns[i].name != "id", true
+}
+
+func (r *fakeRowsIter) ColumnTypeScanType(i int) reflect.Type {
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+func init() {
+	sql.Register("savfake", fakeDriver{})
+}
+
+func TestWriteSQLRows(t *testing.T) {
+	db, err := sql.Open("savfake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+	file, err := os.Create(path + ".sav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	err = sav.WriteSQLRows(file, rows, &sav.SQLOptions{
+		Column: func(ct *sql.ColumnType, d *sav.Dict) {
+			if ct.Name() == "amount" {
+				d.Label = "Amount paid"
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if len(f.Cases) != 2 || f.NCases != 2 {
+		t.Fatalf("got %d cases", len(f.Cases))
+	}
+	if f.Vars[3].Label != "Amount paid" || f.Vars[3].Print != 5<<16|12<<8|2 {
+		t.Errorf("unexpected amount variable %+v", f.Vars[3])
+	}
+	if f.Vars[9].Print != 5<<16|8<<8|5 || f.Vars[10].Print != 5<<16|40<<8|16 {
+		t.Errorf("got formats %x and %x wait F8.5 and F40.16", f.Vars[9].Print, f.Vars[10].Print)
+	}
+	if f.num(0, 9) != -0.12345 || f.num(0, 10) != 1.5 {
+		t.Errorf("got decimals %v and %v", f.num(0, 9), f.num(0, 10))
+	}
+
+	born := float64(time.Date(1971, 1, 31, 0, 0, 0, 0, time.UTC).Unix() + sav.TimeOffset)
+	seen := float64(time.Date(2009, 3, 5, 13, 13, 37, 0, time.UTC).Unix() + sav.TimeOffset)
+	if f.num(0, 0) != 1 || f.str(0, 1, 2) != "Ann" || f.num(0, 3) != 12.5 || f.num(0, 4) != 1 ||
+		f.num(0, 5) != born || f.num(0, 6) != seen || f.num(0, 7) != 7 || f.num(0, 8) != 1 {
+		t.Errorf("unexpected first case %v", f.Cases[0])
+	}
+	if f.num(1, 3) != -math.MaxFloat64 || f.num(1, 4) != 0 || f.str(1, 1, 2) != "" || f.num(1, 8) != 0 {
+		t.Errorf("unexpected second case %v", f.Cases[1])
+	}
+	if !strings.Contains(string(f.Ext[13]), "=id_2") {
+		t.Errorf("duplicate column not renamed: %q", f.Ext[13])
+	}
+}
@@ -0,0 +1,711 @@
+package sav
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LabelProvider is implemented by code types that list their own value labels.
+// Labels are only derived from fmt.Stringer with the labels tag option.
+type LabelProvider interface {
+	SavLabels() map[int]string
+}
+
+// maxStringerCodes is the largest number of codes of a labels tag option
+const maxStringerCodes = 1 << 16
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	labelProviderType = reflect.TypeOf((*LabelProvider)(nil)).Elem()
+	valuerType        = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+	// goNumberFormat parses numbers formatted by strconv, whatever the writer's NumberFormat is
+	goNumberFormat = &NumberFormat{}
+)
+
+// structColumn is one variable derived from a struct field
+type structColumn struct {
+	dict  Dict
+	value func(reflect.Value) reflect.Value // field value from the struct value
+	check func(reflect.Value) error         // optional check of the struct value
+}
+
+// StructEncoder derives variables and cases from Go structs.
+//
+// Fields are configured with the sav struct tag: the first item is the variable name,
+// the other items are key=value options: type (numeric, date, datetime, string, bool),
+// label, measure (scale, nominal, ordinal), width, decimals, default, columns
+// (display width), align (left, right, center) and labels (a range of codes
+// like 1-5 whose fmt.Stringer names are the value labels).
+// A tag of "-" skips the field. For example:
+//
+//	Age int `sav:"age,label=What is your age?,measure=scale"`
+//
+// Nested structs are flattened, their variable names are prefixed with the
+// name of the struct field (or its tag name) and the separator. Embedded
+// structs and fields tagged with the inline option are flattened without prefix.
+//
+// Arrays are expanded into one variable per element, slices need a len option
+// giving the number of variables, shorter slices are padded with missing values.
+// Elements share the options and value labels of the field and are named with
+// IndexFormat, for example Scores [10]int `sav:"q1"` becomes q1_1 to q1_10.
+type StructEncoder struct {
+	Separator   string // joins the names of nested struct fields, "." when empty
+	IndexFormat string // fmt format of array element names from the name and 1-based index, "%s_%d" when empty
+}
+
+// DictFromStruct returns the dictionary for the exported fields of the struct (or pointer to struct) v
+func DictFromStruct(v interface{}) ([]Dict, error) {
+	return (&StructEncoder{}).Dict(v)
+}
+
+// ValsFromStruct returns the values of the struct (or pointer to struct) v as a case
+// matching the dictionary returned by DictFromStruct
+func ValsFromStruct(v interface{}) ([]Val, error) {
+	return (&StructEncoder{}).Vals(v)
+}
+
+// GenerateNativeSavFromStructs writes rows, a slice of structs, to filePath + ".sav"
+func GenerateNativeSavFromStructs(filePath string, rows interface{}) error {
+	return (&StructEncoder{}).GenerateNativeSav(filePath, rows)
+}
+
+// Dict returns the dictionary for the exported fields of the struct (or pointer to struct) v
+func (e *StructEncoder) Dict(v interface{}) ([]Dict, error) {
+	columns, err := e.structColumns(reflect.TypeOf(v))
+	if err != nil {
+		return nil, err
+	}
+
+	return columnsDict(columns), nil
+}
+
+// Vals returns the values of the struct (or pointer to struct) v as a case
+// matching the dictionary returned by Dict
+func (e *StructEncoder) Vals(v interface{}) ([]Val, error) {
+	rv := reflect.ValueOf(v)
+	columns, err := e.structColumns(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+
+	return structVals(columns, reflect.Indirect(rv))
+}
+
+// GenerateNativeSav writes rows, a slice of structs, to filePath + ".sav"
+func (e *StructEncoder) GenerateNativeSav(filePath string, rows interface{}) error {
+	rv := reflect.ValueOf(rows)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return fmt.Errorf("expected a slice of structs, got %s", rv.Type())
+	}
+
+	columns, err := e.structColumns(rv.Type().Elem())
+	if err != nil {
+		return err
+	}
+
+	cases := make([][]Val, rv.Len())
+	for i := range cases {
+		if cases[i], err = structVals(columns, reflect.Indirect(rv.Index(i))); err != nil {
+			return err
+		}
+	}
+
+	return GenerateNativeSav(filePath, columnsDict(columns), cases)
+}
+
+// ValOf converts a Go value to a Val in the text layout WriteCase accepts.
+// Numbers, booleans, strings and time.Time are supported, time.Time is
+// written as a datetime. Nil pointers and invalid sql.Null* values are
+// written as missing.
+func ValOf(name string, value interface{}) (Val, error) {
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() {
+		return Val{Name: name}, nil
+	}
+
+	t, err := dictType(rv.Type())
+	if err != nil {
+		return Val{}, fmt.Errorf("value for %s: %w", name, err)
+	}
+
+	s, err := formatValue(rv, t)
+	if err != nil {
+		return Val{}, fmt.Errorf("value for %s: %w", name, err)
+	}
+
+	return Val{Name: name, Value: s}, nil
+}
+
+func columnsDict(columns []structColumn) []Dict {
+	dict := make([]Dict, len(columns))
+	for i := range columns {
+		dict[i] = columns[i].dict
+	}
+
+	return dict
+}
+
+func structVals(columns []structColumn, rv reflect.Value) ([]Val, error) {
+	vals := make([]Val, len(columns))
+	for i := range columns {
+		if columns[i].check != nil {
+			if err := columns[i].check(rv); err != nil {
+				return nil, err
+			}
+		}
+		s, err := formatValue(columns[i].value(rv), columns[i].dict.Type)
+		if err != nil {
+			return nil, fmt.Errorf("value for %s: %w", columns[i].dict.Name, err)
+		}
+		vals[i] = Val{Name: columns[i].dict.Name, Value: s}
+	}
+
+	return vals, nil
+}
+
+func (e *StructEncoder) structColumns(t reflect.Type) ([]structColumn, error) {
+	if t == nil {
+		return nil, fmt.Errorf("expected a struct, got nil")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("expected a struct, got %s", t)
+	}
+
+	if cleanVarNameRegExp.MatchString(e.separator()) {
+		return nil, fmt.Errorf("separator %q contains characters not allowed in variable names", e.separator())
+	}
+
+	root := func(rv reflect.Value) reflect.Value { return rv }
+
+	return e.appendColumns(nil, t, "", root, map[reflect.Type]bool{})
+}
+
+func (e *StructEncoder) separator() string {
+	if e.Separator == "" {
+		return "."
+	}
+	return e.Separator
+}
+
+// appendColumns adds the variables of the struct type t, parent returns the struct value from the root value
+func (e *StructEncoder) appendColumns(columns []structColumn, t reflect.Type, prefix string,
+	parent func(reflect.Value) reflect.Value, visiting map[reflect.Type]bool) ([]structColumn, error) {
+	if visiting[t] {
+		return nil, fmt.Errorf("recursive struct type %s", t)
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("sav") == "-" || (f.PkgPath != "" && !f.Anonymous) { // skipped or unexported
+			continue
+		}
+		if f.PkgPath != "" && structType(f.Type) == nil { // unexported embedded non struct type
+			continue
+		}
+
+		tag, err := parseTag(f.Tag.Get("sav"))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+
+		index := i
+		value := func(rv reflect.Value) reflect.Value {
+			if rv = indirectPointer(parent(rv)); !rv.IsValid() {
+				return rv
+			}
+			return rv.Field(index)
+		}
+
+		name := tag.name
+		if name == "" && !tag.inline && !f.Anonymous {
+			name = f.Name
+		}
+		if name != "" {
+			name = prefix + name
+		} else if structType(f.Type) == nil { // embedded non struct type
+			name = prefix + f.Name
+		}
+
+		if columns, err = e.appendField(columns, f.Name, f.Type, name, prefix, tag, value, visiting); err != nil {
+			return nil, err
+		}
+	}
+
+	return columns, nil
+}
+
+// appendField adds the variables of a field of type t, name is the full variable name or
+// empty for a struct flattened without its own prefix
+func (e *StructEncoder) appendField(columns []structColumn, fieldName string, t reflect.Type, name, prefix string,
+	tag fieldTag, value func(reflect.Value) reflect.Value, visiting map[reflect.Type]bool) ([]structColumn, error) {
+	if st := structType(t); st != nil {
+		if name != "" {
+			prefix = name + e.separator()
+		}
+		return e.appendColumns(columns, st, prefix, value, visiting)
+	}
+
+	if et := seriesType(t); et != nil {
+		n := tag.length
+		if at := indirectType(t); at.Kind() == reflect.Array {
+			n = at.Len()
+		} else if n <= 0 {
+			return nil, fmt.Errorf("field %s: slice needs a len option", fieldName)
+		}
+
+		first := len(columns)
+		for i := 0; i < n; i++ {
+			element := i
+			elementValue := func(rv reflect.Value) reflect.Value {
+				if rv = indirectPointer(value(rv)); !rv.IsValid() || element >= rv.Len() {
+					return reflect.Value{} // padded with missing
+				}
+				return rv.Index(element)
+			}
+
+			var err error
+			elementName := fmt.Sprintf(e.indexFormat(), name, i+1)
+			if columns, err = e.appendField(columns, fieldName, et, elementName, prefix, tag, elementValue, visiting); err != nil {
+				return nil, err
+			}
+		}
+
+		if tag.length > 0 && len(columns) > first { // values beyond the declared length would be lost
+			check := columns[len(columns)-1].check
+			columns[len(columns)-1].check = func(rv reflect.Value) error {
+				if check != nil {
+					if err := check(rv); err != nil {
+						return err
+					}
+				}
+				if sv := indirectPointer(value(rv)); sv.IsValid() && sv.Len() > n {
+					return fmt.Errorf("field %s has %d elements, more than len=%d", fieldName, sv.Len(), n)
+				}
+				return nil
+			}
+		}
+
+		return columns, nil
+	}
+
+	d, err := fieldDict(fieldName, t, name, tag.opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(columns, structColumn{dict: d, value: value}), nil
+}
+
+func (e *StructEncoder) indexFormat() string {
+	if e.IndexFormat == "" {
+		return "%s_%d"
+	}
+	return e.IndexFormat
+}
+
+// indirectPointer follows pointers, it returns an invalid value for nil pointers
+func indirectPointer(rv reflect.Value) reflect.Value {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return reflect.Value{}
+		}
+		rv = rv.Elem()
+	}
+	return rv
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// structType returns the struct type of a nested struct field, or nil for other fields
+func structType(t reflect.Type) reflect.Type {
+	t = indirectType(t)
+	if t.Kind() != reflect.Struct || t == timeType || isNullType(t) {
+		return nil
+	}
+
+	return t
+}
+
+// seriesType returns the element type of an array or slice field, or nil for other fields
+func seriesType(t reflect.Type) reflect.Type {
+	t = indirectType(t)
+	if t.Kind() != reflect.Array && t.Kind() != reflect.Slice {
+		return nil
+	}
+
+	return t.Elem()
+}
+
+// fieldTag is a parsed sav struct tag
+type fieldTag struct {
+	name   string
+	inline bool     // flatten a nested struct without prefix
+	length int      // number of variables for a slice
+	opts   []string // key=value options for the variable
+}
+
+func parseTag(tag string) (fieldTag, error) {
+	parts := strings.Split(tag, ",")
+	ft := fieldTag{name: parts[0]}
+	for _, opt := range parts[1:] {
+		switch {
+		case strings.TrimSpace(opt) == "inline":
+			ft.inline = true
+		case strings.HasPrefix(opt, "len="):
+			n, err := strconv.Atoi(opt[len("len="):])
+			if err != nil || n <= 0 {
+				return ft, fmt.Errorf("invalid len option %q", opt)
+			}
+			ft.length = n
+		default:
+			ft.opts = append(ft.opts, opt)
+		}
+	}
+
+	return ft, nil
+}
+
+// fieldDict builds the dictionary entry for a value of type t from the sav tag options
+func fieldDict(fieldName string, t reflect.Type, name string, opts []string) (Dict, error) {
+	d := Dict{Name: name}
+
+	var err error
+	if d.Type, err = dictType(t); err != nil {
+		return d, fmt.Errorf("field %s: %w", fieldName, err)
+	}
+
+	vt := valueType(t)
+	switch kind := vt.Kind(); {
+	case kind >= reflect.Int && kind <= reflect.Uintptr:
+		zero := 0
+		d.Decimals = &zero
+		d.NumberFormat = goNumberFormat
+		d.Labels = typeLabels(vt)
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		scale := "scale"
+		d.Measure = &scale
+		d.NumberFormat = goNumberFormat
+	}
+
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return d, fmt.Errorf("field %s: invalid sav tag option %q", fieldName, opt)
+		}
+		key, value := strings.TrimSpace(kv[0]), kv[1]
+
+		switch key {
+		case "type":
+			switch value {
+			case "numeric":
+				d.Type = DictTypeNumeric
+			case "date":
+				d.Type = DictTypeDate
+			case "datetime":
+				d.Type = DictTypeDatetime
+			case "string":
+				d.Type = DictTypeString
+			case "bool":
+				d.Type = DictTypeBool
+			default:
+				return d, fmt.Errorf("field %s: unknown type %s", fieldName, value)
+			}
+		case "label":
+			d.Label = value
+		case "measure":
+			measure := value
+			d.Measure = &measure
+		case "width", "decimals", "columns":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return d, fmt.Errorf("field %s: invalid %s: %w", fieldName, key, err)
+			}
+			switch key {
+			case "width":
+				d.Width = &n
+			case "decimals":
+				d.Decimals = &n
+			default:
+				d.Columns = &n
+			}
+		case "align":
+			align := value
+			d.Alignment = &align
+		case "default":
+			def := value
+			d.Default = &def
+		case "labels":
+			from, to, err := ParseCodeRange(value)
+			if err != nil {
+				return d, fmt.Errorf("field %s: %w", fieldName, err)
+			}
+			if k := vt.Kind(); k < reflect.Int || k > reflect.Uintptr || !vt.Implements(stringerType) {
+				return d, fmt.Errorf("field %s: labels option needs an integer fmt.Stringer type", fieldName)
+			}
+			d.Labels = stringerLabels(vt, from, to)
+		default:
+			return d, fmt.Errorf("field %s: unknown sav tag option %s", fieldName, key)
+		}
+	}
+
+	return d, nil
+}
+
+// isNullType reports whether t is a struct like sql.NullInt64: a driver.Valuer
+// with a Valid flag and one value field
+func isNullType(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct || t.NumField() != 2 {
+		return false
+	}
+	if !t.Implements(valuerType) && !reflect.PtrTo(t).Implements(valuerType) {
+		return false
+	}
+	valid, found := t.FieldByName("Valid")
+
+	return found && valid.Type.Kind() == reflect.Bool
+}
+
+// nullValueField returns the index of the value field of a null type
+func nullValueField(t reflect.Type) int {
+	if t.Field(0).Name == "Valid" {
+		return 1
+	}
+	return 0
+}
+
+// valueType strips pointers and null types from t
+func valueType(t reflect.Type) reflect.Type {
+	for {
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		} else if isNullType(t) {
+			t = t.Field(nullValueField(t)).Type
+		} else {
+			return t
+		}
+	}
+}
+
+// indirectValue strips pointers, interfaces and null types from rv, it
+// returns false when the value is nil or not valid
+func indirectValue(rv reflect.Value) (reflect.Value, bool) {
+	for {
+		switch {
+		case !rv.IsValid():
+			return rv, false
+		case rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface:
+			if rv.IsNil() {
+				return rv, false
+			}
+			rv = rv.Elem()
+		case isNullType(rv.Type()):
+			if !rv.FieldByName("Valid").Bool() {
+				return rv, false
+			}
+			rv = rv.Field(nullValueField(rv.Type()))
+		default:
+			return rv, true
+		}
+	}
+}
+
+// dictType returns the default dictionary type for a Go type
+func dictType(t reflect.Type) (DictType, error) {
+	t = valueType(t)
+	if t == timeType {
+		return DictTypeDatetime, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return DictTypeBool, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return DictTypeNumeric, nil
+	case reflect.String:
+		return DictTypeString, nil
+	}
+
+	return 0, fmt.Errorf("unsupported type %s", t)
+}
+
+// TypeLabels returns the value labels of a LabelProvider value, as
+// DictFromStruct derives them for fields of its type
+func TypeLabels(v interface{}) []Label {
+	if v == nil {
+		return nil
+	}
+	return typeLabels(reflect.TypeOf(v))
+}
+
+// StringerLabels returns the value labels of the codes from to to of an integer
+// fmt.Stringer value, as the labels tag option derives them
+func StringerLabels(v interface{}, from, to int) []Label {
+	if v == nil {
+		return nil
+	}
+	return stringerLabels(reflect.TypeOf(v), from, to)
+}
+
+// ParseCodeRange parses the range of a labels tag option, like 1-5
+func ParseCodeRange(s string) (int, int, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid labels range %q, use from-to", s)
+	}
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid labels range %q: %w", s, err)
+	}
+	to, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid labels range %q: %w", s, err)
+	}
+	if to < from || to-from >= maxStringerCodes {
+		return 0, 0, fmt.Errorf("invalid labels range %q, at most %d codes", s, maxStringerCodes)
+	}
+
+	return from, to, nil
+}
+
+// labelsFromMap returns value labels for codes, ordered by code
+func labelsFromMap(m map[int]string) []Label {
+	codes := make([]int, 0, len(m))
+	for code := range m {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	labels := make([]Label, len(codes))
+	for i, code := range codes {
+		labels[i] = Label{Value: strconv.Itoa(code), Desc: m[code]}
+	}
+
+	return labels
+}
+
+// typeLabels derives value labels from a LabelProvider type
+func typeLabels(t reflect.Type) []Label {
+	if t.Implements(labelProviderType) {
+		return labelsFromMap(reflect.Zero(t).Interface().(LabelProvider).SavLabels())
+	}
+
+	return nil
+}
+
+// stringerLabels derives value labels for the codes from to to of an integer
+// fmt.Stringer type. Codes whose String panics or is not a named constant are
+// left out, as are descriptions shared by several codes like "unknown".
+func stringerLabels(t reflect.Type, from, to int) []Label {
+	if k := t.Kind(); k < reflect.Int || k > reflect.Uintptr || !t.Implements(stringerType) {
+		return nil
+	}
+
+	var labels []Label
+	count := make(map[string]int)
+	for code := from; code <= to; code++ {
+		rv := reflect.New(t).Elem()
+		if t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uintptr {
+			if code < 0 {
+				continue
+			}
+			rv.SetUint(uint64(code))
+			if rv.Uint() != uint64(code) { // overflow of small types
+				break
+			}
+		} else {
+			rv.SetInt(int64(code))
+			if rv.Int() != int64(code) {
+				continue
+			}
+		}
+
+		desc, ok := stringerDesc(rv)
+		if !ok || desc == "" || desc == strconv.Itoa(code) || desc == fmt.Sprintf("%s(%d)", t.Name(), code) {
+			continue // not a named constant, e.g. the fallback of the stringer tool
+		}
+		count[desc]++
+		labels = append(labels, Label{Value: strconv.Itoa(code), Desc: desc})
+	}
+
+	unique := labels[:0]
+	for _, l := range labels {
+		if count[l.Desc] == 1 {
+			unique = append(unique, l)
+		}
+	}
+
+	return unique
+}
+
+// stringerDesc calls String on rv, it returns false when String panics, like
+// for an index out of the range of an array of names
+func stringerDesc(rv reflect.Value) (desc string, ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	return rv.Interface().(fmt.Stringer).String(), true
+}
+
+// formatValue formats a field value in the text layout WriteCase accepts for the given type
+func formatValue(rv reflect.Value, t DictType) (string, error) {
+	rv, ok := indirectValue(rv)
+	if !ok {
+		return "", nil // missing
+	}
+
+	if rv.Type() == timeType {
+		tm := rv.Interface().(time.Time)
+		if tm.IsZero() {
+			return "", nil
+		}
+		if t == DictTypeDate {
+			return tm.Format("2-Jan-2006"), nil
+		}
+		return tm.Format("2-Jan-2006 15:04:05"), nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return "1", nil
+		}
+		return "0", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return "", nil
+		}
+		return strconv.FormatFloat(f, 'g', -1, rv.Type().Bits()), nil
+	case reflect.String:
+		return rv.String(), nil
+	}
+
+	return "", fmt.Errorf("unsupported type %s", rv.Type())
+}
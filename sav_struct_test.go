@@ -0,0 +1,385 @@
+package sav_test
+
+import (
+	"database/sql"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/librun/sav"
+)
+
+type frequency int
+
+const (
+	never frequency = iota + 1
+	sometimes
+	often
+)
+
+func (f frequency) String() string {
+	switch f {
+	case never:
+		return "Never"
+	case sometimes:
+		return "Sometimes"
+	case often:
+		return "Often"
+	}
+	return "frequency(" + strconv.Itoa(int(f)) + ")"
+}
+
+// color is a Stringer backed by an array, String panics for other codes
+type color uint8
+
+var colorNames = [...]string{"red", "green", "blue", "other", "other"}
+
+func (c color) String() string { return colorNames[c] }
+
+type answer int
+
+func (answer) SavLabels() map[int]string {
+	return map[int]string{2: "No", 1: "Yes", 9: "Don't know"}
+}
+
+func (a answer) String() string { return "ignored" }
+
+type survey struct {
+	ID        int       `sav:"id,measure=scale"`
+	Name      string    `sav:"name,label=What is your name?"`
+	Score     float64   `sav:"score,decimals=1"`
+	Finished  bool      `sav:"finished"`
+	Start     time.Time `sav:"start_time"`
+	Birth     time.Time `sav:"dateofbirth,type=date"`
+	Frequency frequency `sav:"frequency,measure=ordinal,labels=0-9"`
+	Answer    answer
+	Skipped   string `sav:"-"`
+	internal  string
+}
+
+func TestStringerLabels(t *testing.T) {
+	dict, err := sav.DictFromStruct(struct {
+		Plain  color
+		Probed color `sav:"probed,labels=0-255"`
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dict[0].Labels != nil {
+		t.Errorf("got labels %+v without labels option", dict[0].Labels)
+	}
+	want := []sav.Label{{Value: "0", Desc: "red"}, {Value: "1", Desc: "green"}, {Value: "2", Desc: "blue"}}
+	if got := dict[1].Labels; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("got labels %+v wait %+v", got, want)
+	}
+	if got := sav.StringerLabels(color(0), 1, 2); len(got) != 2 || got[0] != want[1] {
+		t.Errorf("got labels %+v", got)
+	}
+}
+
+func TestDictFromStruct(t *testing.T) {
+	dict, err := sav.DictFromStruct(&survey{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"id", "name", "score", "finished", "start_time", "dateofbirth", "frequency", "Answer"}
+	types := []sav.DictType{sav.DictTypeNumeric, sav.DictTypeString, sav.DictTypeNumeric, sav.DictTypeBool,
+		sav.DictTypeDatetime, sav.DictTypeDate, sav.DictTypeNumeric, sav.DictTypeNumeric}
+	if len(dict) != len(names) {
+		t.Fatalf("got %d variables wait %d", len(dict), len(names))
+	}
+	for i := range dict {
+		if dict[i].Name != names[i] || dict[i].Type != types[i] {
+			t.Errorf("variable %d: got %s/%d wait %s/%d", i, dict[i].Name, dict[i].Type, names[i], types[i])
+		}
+	}
+
+	if dict[1].Label != "What is your name?" || *dict[2].Decimals != 1 || *dict[6].Measure != "ordinal" {
+		t.Errorf("tag options not applied: %+v", dict)
+	}
+
+	freq := dict[6].Labels
+	if len(freq) != 3 || freq[0] != (sav.Label{Value: "1", Desc: "Never"}) || freq[2] != (sav.Label{Value: "3", Desc: "Often"}) {
+		t.Errorf("unexpected stringer labels %+v", freq)
+	}
+
+	ans := dict[7].Labels
+	if len(ans) != 3 || ans[0] != (sav.Label{Value: "1", Desc: "Yes"}) || ans[2] != (sav.Label{Value: "9", Desc: "Don't know"}) {
+		t.Errorf("unexpected SavLabels labels %+v", ans)
+	}
+
+	if _, err := sav.DictFromStruct(struct {
+		Code int `sav:"code,labels=1-3"`
+	}{}); err == nil {
+		t.Error("expected error for labels option without fmt.Stringer")
+	}
+
+	if _, err := sav.DictFromStruct(struct{ C chan int }{}); err == nil {
+		t.Error("expected error for unsupported field type")
+	}
+
+	display, err := sav.DictFromStruct(struct {
+		Code int `sav:"code,columns=4,align=center"`
+	}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := display[0]; d.Columns == nil || *d.Columns != 4 || d.Alignment == nil || *d.Alignment != "center" {
+		t.Errorf("got display options %+v", d)
+	}
+}
+
+func TestGenerateNativeSavFromStructs(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	start := time.Date(2009, 3, 5, 13, 13, 37, 0, time.UTC)
+	rows := []survey{
+		{ID: 16333, Name: "Test Person 1", Score: 7.5, Finished: true, Start: start, Frequency: sometimes, Answer: 1},
+		{ID: 16334, Score: 1, Frequency: often, Answer: 9},
+	}
+	if err := sav.GenerateNativeSavFromStructs(path, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if len(f.Cases) != 2 {
+		t.Fatalf("got %d cases wait 2", len(f.Cases))
+	}
+	if f.num(0, 0) != 16333 || f.str(0, 1, 2) != "Test Person 1" || f.num(0, 3) != 7.5 || f.num(0, 4) != 1 {
+		t.Errorf("unexpected first case %v", f.Cases[0])
+	}
+	if f.num(0, 5) != float64(start.Unix()+sav.TimeOffset) {
+		t.Errorf("got start time %v", f.num(0, 5))
+	}
+	if f.num(1, 7) != 3 || f.num(1, 8) != 9 {
+		t.Errorf("unexpected codes %v %v", f.num(1, 7), f.num(1, 8))
+	}
+	if len(f.Labels) != 3 { // finished, frequency and Answer
+		t.Errorf("got %d value label sets wait 3", len(f.Labels))
+	}
+}
+
+func TestValOf(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{12, "12"},
+		{uint8(7), "7"},
+		{1.25, "1.25"},
+		{float32(1.1), "1.1"},
+		{true, "1"},
+		{"text", "text"},
+		{time.Date(2007, 2, 4, 1, 2, 3, 0, time.UTC), "4-Feb-2007 01:02:03"},
+		{nil, ""},
+	}
+
+	for _, tt := range tests {
+		val, err := sav.ValOf("x", tt.value)
+		if err != nil {
+			t.Errorf("%v: %v", tt.value, err)
+		} else if val.Value != tt.want {
+			t.Errorf("%v: got %q wait %q", tt.value, val.Value, tt.want)
+		}
+	}
+}
+
+type nullableRow struct {
+	Count    sql.NullInt64   `sav:"count"`
+	Score    sql.NullFloat64 `sav:"score"`
+	Name     sql.NullString  `sav:"name,width=8"`
+	Seen     sql.NullTime    `sav:"seen"`
+	Age      *int            `sav:"age"`
+	Answer   *answer         `sav:"answer"`
+	Comment  *string         `sav:"comment,width=8"`
+	Verified sql.NullBool    `sav:"verified"`
+}
+
+func TestNullableValues(t *testing.T) {
+	dict, err := sav.DictFromStruct(nullableRow{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := []sav.DictType{sav.DictTypeNumeric, sav.DictTypeNumeric, sav.DictTypeString, sav.DictTypeDatetime,
+		sav.DictTypeNumeric, sav.DictTypeNumeric, sav.DictTypeString, sav.DictTypeBool}
+	for i := range dict {
+		if dict[i].Type != types[i] {
+			t.Errorf("%s: got type %d wait %d", dict[i].Name, dict[i].Type, types[i])
+		}
+	}
+	if len(dict[5].Labels) != 3 {
+		t.Errorf("labels of *answer not derived: %+v", dict[5].Labels)
+	}
+
+	age, comment := 45, "fine"
+	seen := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := []nullableRow{
+		{},
+		{
+			Count:    sql.NullInt64{Int64: 3, Valid: true},
+			Score:    sql.NullFloat64{Float64: 2.5, Valid: true},
+			Name:     sql.NullString{String: "abc", Valid: true},
+			Seen:     sql.NullTime{Time: seen, Valid: true},
+			Age:      &age,
+			Comment:  &comment,
+			Verified: sql.NullBool{Bool: true, Valid: true},
+		},
+	}
+
+	vals, err := sav.ValsFromStruct(&rows[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vals {
+		if v.Value != "" {
+			t.Errorf("%s: got %q wait missing", v.Name, v.Value)
+		}
+	}
+
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+	if err := sav.GenerateNativeSavFromStructs(path, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	for _, i := range []int{0, 1, 3, 4, 5, 7} {
+		if f.num(0, i) != -math.MaxFloat64 {
+			t.Errorf("case 0 element %d: got %v wait system missing", i, f.num(0, i))
+		}
+	}
+	if f.str(0, 2, 1) != "" || f.str(0, 6, 1) != "" {
+		t.Errorf("case 0: strings not blank")
+	}
+	if f.num(1, 0) != 3 || f.num(1, 1) != 2.5 || f.str(1, 2, 1) != "abc" || f.num(1, 3) != float64(seen.Unix()+sav.TimeOffset) ||
+		f.num(1, 4) != 45 || f.str(1, 6, 1) != "fine" || f.num(1, 7) != 1 {
+		t.Errorf("unexpected case 1 %v", f.Cases[1])
+	}
+
+	if val, err := sav.ValOf("x", sql.NullInt32{Int32: 7, Valid: true}); err != nil || val.Value != "7" {
+		t.Errorf("ValOf NullInt32: got %q, %v", val.Value, err)
+	}
+	if val, err := sav.ValOf("x", (*float64)(nil)); err != nil || val.Value != "" {
+		t.Errorf("ValOf nil pointer: got %q, %v", val.Value, err)
+	}
+}
+
+type address struct {
+	City string `sav:"city,width=10"`
+	Zip  string `sav:"zip,width=6"`
+}
+
+type base struct {
+	ID int `sav:"id"`
+}
+
+type person struct {
+	base
+	Name    string   `sav:"name,width=10"`
+	Home    address  `sav:"home"`
+	Work    *address `sav:"work"`
+	Contact address  `sav:",inline"`
+	Hidden  address  `sav:"-"`
+}
+
+type node struct {
+	Value int
+	Next  *node
+}
+
+func TestNestedStructs(t *testing.T) {
+	dict, err := sav.DictFromStruct(person{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"id", "name", "home.city", "home.zip", "work.city", "work.zip", "city", "zip"}
+	if len(dict) != len(names) {
+		t.Fatalf("got %d variables wait %d: %+v", len(dict), len(names), dict)
+	}
+	for i := range names {
+		if dict[i].Name != names[i] {
+			t.Errorf("variable %d: got %s wait %s", i, dict[i].Name, names[i])
+		}
+	}
+
+	vals, err := (&sav.StructEncoder{Separator: "_"}).Vals(person{
+		base: base{ID: 4},
+		Home: address{City: "Utrecht"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vals[0].Value != "4" || vals[2].Name != "home_city" || vals[2].Value != "Utrecht" || vals[4].Value != "" {
+		t.Errorf("unexpected values %+v", vals)
+	}
+
+	if _, err := (&sav.StructEncoder{Separator: "-"}).Dict(person{}); err == nil {
+		t.Error("expected error for invalid separator")
+	}
+	if _, err := sav.DictFromStruct(node{}); err == nil {
+		t.Error("expected error for recursive struct")
+	}
+}
+
+type repeated struct {
+	Scores   [3]frequency `sav:"q1,measure=ordinal,labels=1-3"`
+	Options  []string     `sav:"opt,len=2,width=4"`
+	Visits   []address    `sav:"visit,len=2"`
+	Children *[2]int      `sav:"child"`
+}
+
+func TestSeries(t *testing.T) {
+	dict, err := sav.DictFromStruct(repeated{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"q1_1", "q1_2", "q1_3", "opt_1", "opt_2",
+		"visit_1.city", "visit_1.zip", "visit_2.city", "visit_2.zip", "child_1", "child_2"}
+	if len(dict) != len(names) {
+		t.Fatalf("got %d variables wait %d: %+v", len(dict), len(names), dict)
+	}
+	for i := range names {
+		if dict[i].Name != names[i] {
+			t.Errorf("variable %d: got %s wait %s", i, dict[i].Name, names[i])
+		}
+	}
+	if len(dict[2].Labels) != 3 || *dict[2].Measure != "ordinal" || *dict[4].Width != 4 {
+		t.Errorf("element options not shared: %+v", dict[:5])
+	}
+
+	vals, err := sav.ValsFromStruct(repeated{
+		Scores:  [3]frequency{never, often, sometimes},
+		Options: []string{"a"},
+		Visits:  []address{{City: "Delft"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1", "3", "2", "a", "", "Delft", "", "", "", "", ""}
+	for i := range want {
+		if vals[i].Value != want[i] {
+			t.Errorf("%s: got %q wait %q", vals[i].Name, vals[i].Value, want[i])
+		}
+	}
+
+	if _, err := sav.ValsFromStruct(repeated{Options: []string{"a", "b", "c"}}); err == nil {
+		t.Error("expected error for slice longer than len")
+	}
+
+	dict, err = (&sav.StructEncoder{IndexFormat: "%s_%02d"}).Dict(struct {
+		A [2]int `sav:"a"`
+	}{})
+	if err != nil || dict[1].Name != "a_02" {
+		t.Errorf("index format not applied: %+v, %v", dict, err)
+	}
+
+	if _, err := sav.DictFromStruct(struct{ S []int }{}); err == nil {
+		t.Error("expected error for slice without len")
+	}
+}
@@ -1,9 +1,11 @@
 package sav_test
 
 import (
+	"math"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/librun/sav"
 )
@@ -19,3 +21,32 @@ func TestConvertIntToColumnName(t *testing.T) {
 		}
 	}
 }
+
+func TestDateDefaultsAndMissing(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	day, moment := "4-Feb-2007", "4-Feb-2007 10:00:00"
+	err := sav.GenerateNativeSav(path, []sav.Dict{
+		{Name: "born", Type: sav.DictTypeDate, Default: &day},
+		{Name: "seen", Type: sav.DictTypeDatetime, Default: &moment},
+		{Name: "num", Type: sav.DictTypeNumeric},
+	}, [][]sav.Val{
+		{{Name: "num", Value: "1"}},
+		{{Name: "born", Value: ""}, {Name: "seen", Value: ""}, {Name: "num", Value: "2"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	born := float64(time.Date(2007, 2, 4, 0, 0, 0, 0, time.UTC).Unix() + sav.TimeOffset)
+	want := [][]float64{{born, born + 10*3600, 1}, {-math.MaxFloat64, -math.MaxFloat64, 2}}
+	for c := range want {
+		for i, w := range want[c] {
+			if got := f.num(c, i); got != w {
+				t.Errorf("case %d var %d: got %v want %v", c, i, got, w)
+			}
+		}
+	}
+}
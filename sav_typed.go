@@ -0,0 +1,160 @@
+package sav
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"time"
+)
+
+// defaultTypedStringWidth is the width of string variables without width option in a TypedWriter
+const defaultTypedStringWidth = 255
+
+// TypedWriterOptions configures a TypedWriter
+type TypedWriterOptions struct {
+	StructEncoder        // naming of nested struct fields and series
+	FileLabel     string // label in the file header
+	StringWidth   int    // width of string fields without width option, 255 when zero
+	// Configure is called before the dictionary is written, it can set
+	// options of the writer like Encoding or Now
+	Configure func(out *SpssWriter)
+}
+
+// TypedWriter streams values of the struct type T as cases. The dictionary is
+// derived once from T, see StructEncoder for the struct tags.
+type TypedWriter[T any] struct {
+	out     *SpssWriter
+	columns []structColumn
+}
+
+// NewTypedWriter derives the dictionary from T and writes it to w, opts may be nil
+func NewTypedWriter[T any](w io.WriteSeeker, opts *TypedWriterOptions) (*TypedWriter[T], error) {
+	if opts == nil {
+		opts = &TypedWriterOptions{}
+	}
+
+	columns, err := opts.structColumns(reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return nil, err
+	}
+
+	stringWidth := opts.StringWidth
+	if stringWidth <= 0 {
+		stringWidth = defaultTypedStringWidth
+	}
+	length := func(string) (int, error) { return stringWidth, nil }
+
+	tw := &TypedWriter[T]{out: NewSpssWriter(w), columns: columns}
+	for _, c := range columns {
+		v, err := newVar(c.dict, length)
+		if err != nil {
+			return nil, err
+		}
+		tw.out.AddVar(v)
+	}
+
+	if opts.Configure != nil {
+		opts.Configure(tw.out)
+	}
+	if err := tw.out.Start(opts.FileLabel); err != nil {
+		return nil, err
+	}
+
+	return tw, nil
+}
+
+// Writer returns the underlying SpssWriter
+func (tw *TypedWriter[T]) Writer() *SpssWriter {
+	return tw.out
+}
+
+// Write writes v as a case
+func (tw *TypedWriter[T]) Write(v T) error {
+	rv := reflect.ValueOf(&v).Elem()
+
+	tw.out.ClearCase()
+	for i := range tw.columns {
+		c := &tw.columns[i]
+		if c.check != nil {
+			if err := c.check(rv); err != nil {
+				return err
+			}
+		}
+		if err := setColumn(tw.out, c.dict, c.value(rv)); err != nil {
+			return err
+		}
+	}
+
+	return tw.out.WriteCase()
+}
+
+// WriteAll writes all values as cases
+func (tw *TypedWriter[T]) WriteAll(values []T) error {
+	for i := range values {
+		if err := tw.Write(values[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close updates the number of cases in the header, it does not close the underlying writer
+func (tw *TypedWriter[T]) Close() error {
+	return tw.out.Finish()
+}
+
+// setColumn sets a field value with the typed setters of the writer
+func setColumn(out *SpssWriter, d Dict, rv reflect.Value) error {
+	rv, ok := indirectValue(rv)
+	if !ok {
+		out.SetMissing(d.Name)
+		return nil
+	}
+
+	if rv.Type() == timeType && d.Type != DictTypeString {
+		out.SetTime(d.Name, rv.Interface().(time.Time))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		if d.Type == DictTypeString {
+			break
+		}
+		if rv.Bool() {
+			out.SetNumber(d.Name, 1)
+		} else {
+			out.SetNumber(d.Name, 0)
+		}
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if d.Type != DictTypeString {
+			out.SetNumber(d.Name, float64(rv.Int()))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if d.Type != DictTypeString {
+			out.SetNumber(d.Name, float64(rv.Uint()))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		if d.Type != DictTypeString {
+			if f := rv.Float(); math.IsInf(f, 0) {
+				out.SetMissing(d.Name)
+			} else {
+				out.SetNumber(d.Name, f)
+			}
+			return nil
+		}
+	}
+
+	s, err := formatValue(rv, d.Type)
+	if err != nil {
+		return fmt.Errorf("value for %s: %w", d.Name, err)
+	}
+	out.SetVar(d.Name, s)
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package sav_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/librun/sav"
+)
+
+func TestTypedWriter(t *testing.T) {
+	path, cleanup := tempSavPath(t)
+	defer cleanup()
+
+	file, err := os.Create(path + ".sav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tw, err := sav.NewTypedWriter[person](file, &sav.TypedWriterOptions{
+		StructEncoder: sav.StructEncoder{Separator: "_"},
+		FileLabel:     "people",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer's number format does not apply to Go numbers
+	tw.Writer().NumberFormat = &sav.NumberFormat{DecimalSeparator: ","}
+
+	if err := tw.Write(person{base: base{ID: 1}, Name: "Ann", Home: address{City: "Delft"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.WriteAll([]person{{base: base{ID: 2}}, {base: base{ID: 3}, Work: &address{Zip: "1234AB"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f := readSavFile(t, path+".sav")
+	if f.NCases != 3 || len(f.Cases) != 3 || f.Label != "people" {
+		t.Fatalf("got %d/%d cases and label %q", f.NCases, len(f.Cases), f.Label)
+	}
+	if f.num(0, 0) != 1 || f.str(0, 1, 2) != "Ann" || f.num(2, 0) != 3 {
+		t.Errorf("unexpected cases %v", f.Cases)
+	}
+	if i := f.varIndex("HOME_0"); i < 0 || f.Vars[i].Type != 10 {
+		t.Errorf("unexpected variables %+v", f.Vars)
+	}
+}
@@ -0,0 +1,87 @@
+package sav
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// varSet is a named group of variables shown in the Use Variable Sets dialog
+type varSet struct {
+	name  string
+	names []string
+}
+
+// AddVarSet declares a variable set over the names of Dict entries or added
+// variables. The names are checked when the dictionary is written.
+func (out *SpssWriter) AddVarSet(name string, vars ...string) error {
+	if strings.TrimSpace(name) == "" || strings.ContainsAny(name, "=\n") {
+		return fmt.Errorf("invalid variable set name %q", name)
+	}
+	for _, s := range out.varSets {
+		if strings.EqualFold(s.name, name) {
+			return fmt.Errorf("duplicate variable set %s", name)
+		}
+	}
+
+	out.varSets = append(out.varSets, varSet{name: name, names: vars})
+	return nil
+}
+
+// checkVarSets returns an error for variable set members that are not variables
+func (out *SpssWriter) checkVarSets() error {
+	var missing []string
+	for _, s := range out.varSets {
+		for _, n := range s.names {
+			if _, found := out.DictMap[n]; !found {
+				missing = append(missing, fmt.Sprintf("%s in %s", n, s.name))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("unknown variables in variable sets: %s", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+func (out *SpssWriter) varSetsRecord() error {
+	if len(out.varSets) == 0 {
+		return nil
+	}
+
+	buf := bytes.Buffer{}
+	for _, s := range out.varSets {
+		buf.WriteString(out.encodeText("", s.name))
+		buf.WriteString("=")
+		for _, n := range s.names {
+			v := out.DictMap[n]
+			buf.WriteString(" ")
+			buf.WriteString(out.encodeText(v.Name, v.Name))
+		}
+		buf.WriteString("\n")
+	}
+
+	if err := binary.Write(out, endian, int32(7)); err != nil { // rec_type
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(5)); err != nil { // subtype
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(1)); err != nil { // size
+		return err
+	}
+
+	if err := binary.Write(out, endian, int32(buf.Len())); err != nil { // count
+		return err
+	}
+
+	if _, err := out.Write(buf.Bytes()); err != nil { // sets
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package sav
+
+import (
+	"fmt"
+	"log"
+	"unicode/utf8"
+)
+
+// WarningKind tells what happened to a value
+type WarningKind int
+
+const (
+	// WarningTruncated is reported for a string longer than the width of its variable
+	WarningTruncated WarningKind = iota
+	// WarningInvalid is reported for a value that can not be parsed, it is written as missing
+	WarningInvalid
+	// WarningUnencodable is reported for characters that can not be represented
+	// in the encoding of the writer, they are replaced by '?'
+	WarningUnencodable
+	// WarningRenamed is reported for a variable renamed by NamePolicyFix, Value
+	// is the original name and Var the new one
+	WarningRenamed
+	// WarningLabel is reported for a value label that is left out or truncated
+	WarningLabel
+)
+
+// Warning describes a value that was changed while writing a case
+type Warning struct {
+	Kind   WarningKind
+	Var    string // variable name
+	Case   int64  // index of the case, starting at 0, -1 for the dictionary
+	Value  string // original value
+	Length int    // length of the original value in bytes
+	Width  int    // width of the variable in bytes, for WarningTruncated
+	Err    error  // parse error for WarningInvalid, characters for WarningUnencodable
+}
+
+func (w Warning) String() string {
+	switch w.Kind {
+	case WarningTruncated:
+		return fmt.Sprintf("Truncated string for %s in case %d from %d to %d bytes: %s", w.Var, w.Case, w.Length, w.Width, w.Value)
+	case WarningLabel:
+		return fmt.Sprintf("Problem with value label %q of %s: %s", w.Value, w.Var, w.Err)
+	case WarningRenamed:
+		return fmt.Sprintf("Change variable name '%s' to '%s'", w.Value, w.Var)
+	case WarningUnencodable:
+		if w.Case < 0 {
+			return fmt.Sprintf("Problem encoding dictionary text for %s: %s", w.Var, w.Err)
+		}
+		return fmt.Sprintf("Problem encoding value for %s in case %d: %s", w.Var, w.Case, w.Err)
+	}
+	return fmt.Sprintf("Problem pasing value for %s in case %d: %s - set as missing", w.Var, w.Case, w.Err)
+}
+
+// warn reports w to the Warn handler of the writer, or logs it when there is none
+func (out *SpssWriter) warn(w Warning) {
+	if out.Warn != nil {
+		out.Warn(w)
+		return
+	}
+	log.Println(w)
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a UTF-8 encoded rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}